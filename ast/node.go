@@ -80,6 +80,15 @@ type Container struct {
 	Literal []byte // Text contents of the leaf nodes
 	Content []byte // Markdown content of the block nodes
 
+	// Byte offset and line number, starting at 1, of the block in the
+	// parsed markdown, set with the parser.SourcePositions flag. Only
+	// top-level blocks from the input have a position. For the other nodes,
+	// i.e. blocks nested in lists, quotes or tables, inline nodes and the
+	// footnotes added after parsing, both are -1. Without the flag, all
+	// are 0.
+	SourceOffset int
+	SourceLine   int
+
 	*Attribute // Block level attribute
 }
 
@@ -120,6 +129,15 @@ type Leaf struct {
 	Literal []byte // Text contents of the leaf nodes
 	Content []byte // Markdown content of the block nodes
 
+	// Byte offset and line number, starting at 1, of the block in the
+	// parsed markdown, set with the parser.SourcePositions flag. Only
+	// top-level blocks from the input have a position. For the other nodes,
+	// i.e. blocks nested in lists, quotes or tables, inline nodes and the
+	// footnotes added after parsing, both are -1. Without the flag, all
+	// are 0.
+	SourceOffset int
+	SourceLine   int
+
 	*Attribute // Block level attribute
 }
 
@@ -169,6 +187,8 @@ type DocumentMatter struct {
 // BlockQuote represents markdown block quote node
 type BlockQuote struct {
 	Container
+
+	Alert string // type of a GitHub alert like > [!NOTE], lowercased, e.g. "note"
 }
 
 // Aside represents an markdown aside node.
@@ -197,6 +217,7 @@ type ListItem struct {
 	Tight           bool   // Skip <p>s around list item data if true
 	BulletChar      byte   // '*', '+' or '-' in bullet lists
 	Delimiter       byte   // '.' or ')' after the number in ordered lists
+	Number          int    // number written before the item in ordered lists
 	RefLink         []byte // If not nil, turns this list item into a footnote item and triggers different rendering
 	IsFootnotesList bool   // This is a list of footnotes
 }
@@ -246,6 +267,16 @@ type Del struct {
 	Container
 }
 
+// Mark represents markdown highlighted text node
+type Mark struct {
+	Container
+}
+
+// Ins represents markdown inserted text node
+type Ins struct {
+	Container
+}
+
 // Link represents markdown link node
 type Link struct {
 	Container
@@ -279,6 +310,8 @@ type Image struct {
 
 	Destination []byte // Destination is what goes into a href
 	Title       []byte // Title is the tooltip thing that goes in a title attribute
+	Width       int    // Width in pixels from a size hint, 0 if not given
+	Height      int    // Height in pixels from a size hint, 0 if not given
 }
 
 // Text represents markdown text node
@@ -300,10 +333,11 @@ type CodeBlock struct {
 	FenceChar   byte
 	FenceLength int
 	FenceOffset int
+	Unclosed    bool // fenced code block without a closing fence, running to the end of its container
 }
 
-// Softbreak represents markdown softbreak node
-// Note: not used currently
+// Softbreak represents markdown softbreak node, i.e. a single newline
+// inside a paragraph
 type Softbreak struct {
 	Leaf
 }
@@ -334,6 +368,7 @@ type TableCell struct {
 
 	IsHeader bool           // This tells if it's under the header row
 	Align    CellAlignFlags // This holds the value for align attribute
+	ColSpan  int            // Number of columns the cell spans, 0 and 1 mean one
 }
 
 // TableHeader represents markdown table head node
@@ -395,6 +430,18 @@ type Superscript struct {
 	Leaf
 }
 
+// Abbreviation is an abbreviation defined with *[ABBR]: title
+type Abbreviation struct {
+	Leaf
+
+	Title []byte // the expansion of the abbreviation
+}
+
+// Kbd is a keyboard key written as [[[Ctrl]]]
+type Kbd struct {
+	Leaf
+}
+
 // Footnotes is a node that contains all footnotes
 type Footnotes struct {
 	Container
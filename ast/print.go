@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"unicode/utf8"
 )
 
 // Print is for debugging. It prints a string representation of parsed
@@ -66,8 +67,13 @@ func shortenString(s string, maxLen int) string {
 	if len(s) < maxLen {
 		return s
 	}
-	// add "..." to indicate truncation
-	return s[:maxLen-3] + "..."
+	// add "..." to indicate truncation, making sure we don't cut
+	// a multi-byte character in half
+	end := maxLen - 3
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end] + "..."
 }
 
 // get a short name of the type of v which excludes package name
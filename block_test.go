@@ -83,6 +83,121 @@ func TestPrefixAutoHeaderIdExtensionWithPrefixAndSuffix(t *testing.T) {
 	})
 }
 
+func TestHeadingAnchors(t *testing.T) {
+	tests := []string{
+		"# Header 1\n",
+		"<h1 id=\"header-1\">Header 1<a href=\"#header-1\" class=\"anchor\">¶</a></h1>\n",
+
+		"## Header {#custom}\n\n## Header {#custom}\n",
+		"<h2 id=\"custom\">Header<a href=\"#custom\" class=\"anchor\">¶</a></h2>\n\n<h2 id=\"custom-1\">Header<a href=\"#custom-1\" class=\"anchor\">¶</a></h2>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions: parser.AutoHeadingIDs | parser.HeadingIDs,
+		Flags:      html.HeadingAnchors,
+	})
+
+	tests = []string{
+		"# Header 1\n",
+		"<h1 id=\"header-1\">Header 1<a href=\"#header-1\" class=\"permalink\">#</a></h1>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions: parser.AutoHeadingIDs,
+		Flags:      html.HeadingAnchors,
+		RendererOptions: html.RendererOptions{
+			HeadingAnchorText:  "#",
+			HeadingAnchorClass: "permalink",
+		},
+	})
+
+	// no anchor without the flag or without an id
+	tests = []string{
+		"# Header 1\n",
+		"<h1 id=\"header-1\">Header 1</h1>\n",
+	}
+	doTestsParam(t, tests, TestParams{extensions: parser.AutoHeadingIDs})
+
+	tests = []string{
+		"# Header 1\n",
+		"<h1>Header 1</h1>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.HeadingAnchors})
+}
+
+func TestNumberHeadings(t *testing.T) {
+	tests := []string{
+		"# One\n\n## One.One\n\n## One.Two\n\n### One.Two.One\n\n# Two\n\n## Two.One\n",
+		"<h1>1 One</h1>\n\n<h2>1.1 One.One</h2>\n\n<h2>1.2 One.Two</h2>\n\n<h3>1.2.1 One.Two.One</h3>\n\n<h1>2 Two</h1>\n\n<h2>2.1 Two.One</h2>\n",
+
+		// skipped levels don't leave a gap
+		"# One\n\n### One.One\n\n## One.Two\n\n### One.Two.One\n",
+		"<h1>1 One</h1>\n\n<h3>1.1 One.One</h3>\n\n<h2>1.2 One.Two</h2>\n\n<h3>1.2.1 One.Two.One</h3>\n",
+
+		// a document starting below h1
+		"## One\n\n## Two\n\n#### Two.One\n",
+		"<h2>1 One</h2>\n\n<h2>2 Two</h2>\n\n<h4>2.1 Two.One</h4>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.NumberHeadings})
+
+	tests = []string{
+		"# Header\n",
+		"<h1 id=\"header\">1 Header<a href=\"#header\" class=\"anchor\">¶</a></h1>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions: parser.AutoHeadingIDs,
+		Flags:      html.NumberHeadings | html.HeadingAnchors,
+	})
+}
+
+func TestMinHeadingLevel(t *testing.T) {
+	tests := []string{
+		"## Top\n\n### Sub\n\n## Other\n",
+		"<h1>Top</h1>\n\n<h2>Sub</h2>\n\n<h1>Other</h1>\n",
+
+		"###### Deep\n",
+		"<h1>Deep</h1>\n",
+
+		"no headings\n",
+		"<p>no headings</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{MinHeadingLevel: 1},
+	})
+
+	tests = []string{
+		"# Top\n\n## Sub\n\n###### Deepest\n",
+		"<h2>Top</h2>\n\n<h3>Sub</h3>\n\n<h6>Deepest</h6>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{MinHeadingLevel: 2},
+	})
+}
+
+func TestAbbreviations(t *testing.T) {
+	tests := []string{
+		"The HTML specification is maintained by the W3C.\n\n*[HTML]: Hyper Text Markup Language\n*[W3C]:  World Wide Web Consortium\n",
+		"<p>The <abbr title=\"Hyper Text Markup Language\">HTML</abbr> specification is maintained by the <abbr title=\"World Wide Web Consortium\">W3C</abbr>.</p>\n",
+
+		// whole words only, longest first
+		"*[HTML]: Hyper <Text>\n*[HTML5]: Five\n\nHTML and HTML5 and XHTML and HTMLs\n",
+		"<p><abbr title=\"Hyper &lt;Text&gt;\">HTML</abbr> and <abbr title=\"Five\">HTML5</abbr> and XHTML and HTMLs</p>\n",
+
+		// not in code
+		"*[HTML]: Hyper Text Markup Language\n\n# About HTML\n\n`HTML` [HTML](/x)\n",
+		"<h1>About <abbr title=\"Hyper Text Markup Language\">HTML</abbr></h1>\n\n<p><code>HTML</code> <a href=\"/x\"><abbr title=\"Hyper Text Markup Language\">HTML</abbr></a></p>\n",
+
+		"*[HTML]:\n\nHTML\n",
+		"<p><abbr>HTML</abbr></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{extensions: parser.Abbreviations})
+
+	// without the extension
+	tests = []string{
+		"HTML\n\n*[HTML]: Hyper Text Markup Language\n",
+		"<p>HTML</p>\n\n<p>*[HTML]: Hyper Text Markup Language</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{})
+}
+
 func TestPrefixMultipleHeaderExtensions(t *testing.T) {
 	tests := readTestFile2(t, "PrefixMultipleHeaderExtensions.tests")
 	doTestsBlock(t, tests, parser.AutoHeadingIDs|parser.HeadingIDs)
@@ -108,6 +223,43 @@ func TestHorizontalRule(t *testing.T) {
 	doTestsBlock(t, tests, 0)
 }
 
+func TestHorizontalRuleOptions(t *testing.T) {
+	tests := []string{
+		"---\n",
+		"<hr class=\"separator\">\n",
+
+		"{.wide}\n---\n",
+		"<hr class=\"wide separator\">\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions: parser.Attributes,
+		RendererOptions: html.RendererOptions{
+			HorizontalRuleClass: "separator",
+		},
+	})
+
+	tests = []string{
+		"---\n",
+		"<hr class=\"separator\" />\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		Flags: html.UseXHTML,
+		RendererOptions: html.RendererOptions{
+			HorizontalRuleClass: "separator",
+		},
+	})
+
+	tests = []string{
+		"a\n\n***\n\nb\n",
+		"<p>a</p>\n\n<div class=\"divider\"></div>\n\n<p>b</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{
+			HorizontalRule: `<div class="divider"></div>`,
+		},
+	})
+}
+
 func TestUnorderedList(t *testing.T) {
 	tests := readTestFile2(t, "UnorderedList.tests")
 	doTestsBlock(t, tests, 0)
@@ -118,6 +270,105 @@ func TestOrderedList(t *testing.T) {
 	doTestsBlock(t, tests, 0)
 }
 
+func TestTabIndentedListItems(t *testing.T) {
+	tests := []string{
+		"-\ta\n\t-\tb\n",
+		"<ul>\n<li>a\n\n<ul>\n<li>b</li>\n</ul></li>\n</ul>\n",
+
+		"- a\n  \t- b\n",
+		"<ul>\n<li>a\n\n<ul>\n<li>b</li>\n</ul></li>\n</ul>\n",
+
+		"- \ta\n",
+		"<ul>\n<li>a</li>\n</ul>\n",
+
+		"1.\ta\n\n\tb\n",
+		"<ol>\n<li><p>a</p>\n\n<p>b</p></li>\n</ol>\n",
+
+		"- a\n\n  \tb\n",
+		"<ul>\n<li><p>a</p>\n\n<p>b</p></li>\n</ul>\n",
+
+		"  \tcode\n",
+		"<pre><code>code\n</code></pre>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestTabIndentedBlockquotes(t *testing.T) {
+	tests := []string{
+		">\ta\n",
+		"<blockquote>\n<p>a</p>\n</blockquote>\n",
+
+		">\ta\n>\tb\n",
+		"<blockquote>\n<p>a\nb</p>\n</blockquote>\n",
+
+		">\t\tcode\n",
+		"<blockquote>\n<pre><code>code\n</code></pre>\n</blockquote>\n",
+
+		">\t-\ta\n",
+		"<blockquote>\n<ul>\n<li>a</li>\n</ul>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestOrderedListValues(t *testing.T) {
+	tests := []string{
+		"1. one\n1. two\n1. three\n",
+		"<ol>\n<li>one</li>\n<li>two</li>\n<li>three</li>\n</ol>\n",
+	}
+	doTestsBlock(t, tests, 0)
+
+	tests = []string{
+		"1. one\n1. two\n1. three\n",
+		"<ol>\n<li>one</li>\n<li value=\"1\">two</li>\n<li value=\"1\">three</li>\n</ol>\n",
+
+		// items in sequence don't need a value
+		"1. one\n2. two\n5. five\n6. six\n",
+		"<ol>\n<li>one</li>\n<li>two</li>\n<li value=\"5\">five</li>\n<li>six</li>\n</ol>\n",
+
+		"3. three\n4. four\n",
+		"<ol>\n<li value=\"3\">three</li>\n<li>four</li>\n</ol>\n",
+
+		"- not\n- ordered\n",
+		"<ul>\n<li>not</li>\n<li>ordered</li>\n</ul>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.OrderedListValues})
+
+	tests = []string{
+		"3. three\n4. four\n3. three\n",
+		"<ol start=\"3\">\n<li>three</li>\n<li>four</li>\n<li value=\"3\">three</li>\n</ol>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions: parser.OrderedListStart,
+		Flags:      html.OrderedListValues,
+	})
+}
+
+func TestOrderedListType(t *testing.T) {
+	tests := []string{
+		"1. one\n2. two\n",
+		"<ol type=\"a\">\n<li>one</li>\n<li>two</li>\n</ol>\n",
+
+		"- not\n- ordered\n",
+		"<ul>\n<li>not</li>\n<li>ordered</li>\n</ul>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{OrderedListType: "a"},
+	})
+
+	tests = []string{
+		"3. three\n4. four\n",
+		"<ol start=\"3\" type=\"I\">\n<li>three</li>\n<li>four</li>\n</ol>\n",
+
+		"1. note[^1]\n\n[^1]: footnote\n",
+		"<ol type=\"I\">\n<li>note<sup class=\"footnote-ref\" id=\"fnref:1\"><a href=\"#fn:1\">1</a></sup></li>\n</ol>\n\n" +
+			"<div class=\"footnotes\">\n\n<hr>\n\n<ol>\n<li id=\"fn:1\">footnote</li>\n</ol>\n\n</div>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions:      parser.OrderedListStart | parser.Footnotes,
+		RendererOptions: html.RendererOptions{OrderedListType: "I"},
+	})
+}
+
 func TestDefinitionList(t *testing.T) {
 	tests := readTestFile2(t, "DefinitionList.tests")
 	doTestsBlock(t, tests, parser.DefinitionLists)
@@ -138,21 +389,275 @@ func TestPreformattedHtmlLax(t *testing.T) {
 	doTestsBlock(t, tests, parser.LaxHTMLBlocks)
 }
 
+func TestHTMLSpecialBlocks(t *testing.T) {
+	tests := []string{
+		"<!DOCTYPE html>\n",
+		"<!DOCTYPE html>\n",
+
+		"<!DOCTYPE html>\n\nparagraph\n",
+		"<!DOCTYPE html>\n\n<p>paragraph</p>\n",
+
+		"<?php\necho \"*hi*\";\n?>\n\nparagraph\n",
+		"<?php\necho \"*hi*\";\n?>\n\n<p>paragraph</p>\n",
+
+		"<?php echo 1; ?>\n",
+		"<?php echo 1; ?>\n",
+
+		"<![CDATA[\na < *b*\n]]>\n",
+		"<![CDATA[\na < *b*\n]]>\n",
+
+		// not closed
+		"<?php echo 1;\n",
+		"<p>&lt;?php echo 1;</p>\n",
+
+		// a declaration starts with a letter
+		"<!1>\n",
+		"<p>&lt;!1&gt;</p>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestHTMLRawTextBlocks(t *testing.T) {
+	tests := []string{
+		// blank lines don't end the block, the closing tag does
+		"<pre>\na\n\n*b*\n</pre>\n\nafter\n",
+		"<pre>\na\n\n*b*\n</pre>\n\n<p>after</p>\n",
+
+		"<script type=\"x\">\nvar a;\n\nvar b;\n</script>\n",
+		"<script type=\"x\">\nvar a;\n\nvar b;\n</script>\n",
+
+		"<STYLE>\np {}\n\n</style>\n",
+		"<STYLE>\np {}\n\n</style>\n",
+
+		// up to the end of the line with the closing tag
+		"<textarea>\n\n</textarea> rest\nnext\n",
+		"<textarea>\n\n</textarea> rest\n\n<p>next</p>\n",
+
+		// not closed
+		"<pre>\nunclosed\n\n# heading\n",
+		"<pre>\nunclosed\n\n# heading\n",
+
+		"<presto>\n\nx\n",
+		"<p><presto></p>\n\n<p>x</p>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
 func TestFencedCodeBlock(t *testing.T) {
 	tests := readTestFile2(t, "FencedCodeBlock.tests")
 	doTestsBlock(t, tests, parser.FencedCode)
 }
 
+func TestFencedCodeBlockUnclosed(t *testing.T) {
+	tests := []string{
+		// everything after an unclosed fence is code
+		"para\n\n```go\ncode\n\n# not a heading\n\n- nor a list",
+		"<p>para</p>\n\n<pre><code class=\"language-go\">code\n\n# not a heading\n\n- nor a list\n</code></pre>\n",
+
+		"text\n```\ncode\n",
+		"<p>text</p>\n\n<pre><code>code\n</code></pre>\n",
+
+		// up to the end of the blockquote
+		"> ```\n> quoted\n\nafter\n",
+		"<blockquote>\n<pre><code>quoted\n</code></pre>\n</blockquote>\n\n<p>after</p>\n",
+	}
+	doTestsBlock(t, tests, parser.FencedCode)
+}
+
 func TestFencedCodeInsideBlockquotes(t *testing.T) {
 	tests := readTestFile2(t, "FencedCodeInsideBlockquotes.tests")
 	doTestsBlock(t, tests, parser.FencedCode)
 }
 
+func TestIndentedCodeInsideBlockquotes(t *testing.T) {
+	tests := []string{
+		">     code\n",
+		"<blockquote>\n<pre><code>code\n</code></pre>\n</blockquote>\n",
+
+		">     code\n>     more code\n",
+		"<blockquote>\n<pre><code>code\nmore code\n</code></pre>\n</blockquote>\n",
+
+		"> text\n>\n>     code\n",
+		"<blockquote>\n<p>text</p>\n\n<pre><code>code\n</code></pre>\n</blockquote>\n",
+
+		// the space after > belongs to the prefix, so three more are not code
+		">    text\n",
+		"<blockquote>\n<p>text</p>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
+func TestTightBlockQuotes(t *testing.T) {
+	tests := []string{
+		"> quote\n",
+		"<blockquote>\n<p>quote</p>\n</blockquote>\n",
+
+		"> one\n>\n> two\n",
+		"<blockquote>\n<p>one</p>\n\n<p>two</p>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+
+	tests = []string{
+		"> quote\n",
+		"<blockquote>\nquote\n</blockquote>\n",
+
+		"> a *quote*\n> on two lines\n",
+		"<blockquote>\na <em>quote</em>\non two lines\n</blockquote>\n",
+
+		"> one\n>\n> two\n",
+		"<blockquote>\n<p>one</p>\n\n<p>two</p>\n</blockquote>\n",
+
+		"> # Title\n> text\n",
+		"<blockquote>\n<h1>Title</h1>\n\n<p>text</p>\n</blockquote>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.TightBlockQuotes})
+}
+
+func TestAlerts(t *testing.T) {
+	tests := []string{
+		"> [!NOTE]\n> Useful *information*.\n",
+		"<div class=\"markdown-alert markdown-alert-note\">\n<p class=\"markdown-alert-title\">Note</p>\n\n" +
+			"<p>Useful <em>information</em>.</p>\n</div>\n",
+
+		"> [!Warning]  \n> One.\n>\n> Two.\n",
+		"<div class=\"markdown-alert markdown-alert-warning\">\n<p class=\"markdown-alert-title\">Warning</p>\n\n" +
+			"<p>One.</p>\n\n<p>Two.</p>\n</div>\n",
+
+		"> A plain quote.\n",
+		"<blockquote>\n<p>A plain quote.</p>\n</blockquote>\n",
+
+		// unknown types and text after the marker are not alerts
+		"> [!OTHER]\n> text\n",
+		"<blockquote>\n<p>[!OTHER]\ntext</p>\n</blockquote>\n",
+
+		"> [!TIP] text\n",
+		"<blockquote>\n<p>[!TIP] text</p>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, parser.Alerts)
+
+	tests = []string{
+		"> [!NOTE]\n> text\n",
+		"<blockquote>\n<p>[!NOTE]\ntext</p>\n</blockquote>\n",
+	}
+	doTestsBlock(t, tests, 0)
+}
+
 func TestTable(t *testing.T) {
 	tests := readTestFile2(t, "Table.tests")
 	doTestsBlock(t, tests, parser.Tables)
 }
 
+func TestEmptyLineBeforeTable(t *testing.T) {
+	table := "<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n" +
+		"<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n"
+	tests := []string{
+		"# Heading\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<h1>Heading</h1>\n\n" + table,
+	}
+	doTestsBlock(t, tests, parser.Tables)
+
+	tests = []string{
+		"# Heading\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<h1>Heading</h1>\n\n<p>| a | b |\n|---|---|\n| 1 | 2 |</p>\n",
+
+		"# Heading\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<h1>Heading</h1>\n\n" + table,
+
+		"| a | b |\n|---|---|\n| 1 | 2 |\n",
+		table,
+
+		"> | a | b |\n> |---|---|\n> | 1 | 2 |\n",
+		"<blockquote>\n" + table + "</blockquote>\n",
+
+		"text\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<p>text</p>\n\n" + table,
+
+		"- item\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<ul>\n<li>item</li>\n</ul>\n\n" + table,
+
+		"> quote\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<blockquote>\n<p>quote</p>\n</blockquote>\n\n" + table,
+	}
+	doTestsBlock(t, tests, parser.Tables|parser.EmptyLineBeforeTable)
+
+	// the same with the common extensions
+	tests = []string{
+		"text\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<p>text</p>\n\n" + table,
+
+		"- item\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<ul>\n<li>item</li>\n</ul>\n\n" + table,
+
+		"text\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+		"<p>text\n| a | b |\n|---|---|\n| 1 | 2 |</p>\n",
+	}
+	doTestsBlock(t, tests, parser.CommonExtensions|parser.EmptyLineBeforeTable)
+}
+
+func TestPipesOutsideTables(t *testing.T) {
+	tests := []string{
+		"a | b\n",
+		"<p>a | b</p>\n",
+
+		"a | b\nc | d\n",
+		"<p>a | b\nc | d</p>\n",
+
+		"| a |\n",
+		"<p>| a |</p>\n",
+
+		"a \\| b\n",
+		"<p>a | b</p>\n",
+
+		// a header line and an underline make a table
+		"a | b\n---|---\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n\n<tbody>\n</tbody>\n</table>\n",
+	}
+	doTestsBlock(t, tests, parser.Tables)
+}
+
+func TestTableColSpan(t *testing.T) {
+	tests := readTestFile2(t, "TableColSpan.tests")
+	doTestsBlock(t, tests, parser.Tables|parser.TableColSpan)
+}
+
+func TestAccessibleTables(t *testing.T) {
+	tests := []string{
+		"| a | b |\n|:--|--:|\n| 1 | 2 |\n",
+		"<table role=\"table\">\n<thead>\n<tr>\n<th align=\"left\" scope=\"col\">a</th>\n<th align=\"right\" scope=\"col\">b</th>\n</tr>\n</thead>\n\n<tbody>\n<tr>\n<td align=\"left\">1</td>\n<td align=\"right\">2</td>\n</tr>\n</tbody>\n</table>\n",
+	}
+	doTestsParam(t, tests, TestParams{extensions: parser.Tables, Flags: html.AccessibleTables})
+}
+
+func TestTableClasses(t *testing.T) {
+	tests := []string{
+		"| a |\n|---|\n| 1 |\n",
+		"<table class=\"table table-striped\">\n<thead class=\"thead-dark\">\n<tr>\n<th>a</th>\n</tr>\n</thead>\n\n" +
+			"<tbody class=\"body\">\n<tr>\n<td>1</td>\n</tr>\n</tbody>\n</table>\n",
+
+		"{.wide}\n| a |\n|---|\n| 1 |\n",
+		"<table class=\"wide table table-striped\">\n<thead class=\"thead-dark\">\n<tr>\n<th>a</th>\n</tr>\n</thead>\n\n" +
+			"<tbody class=\"body\">\n<tr>\n<td>1</td>\n</tr>\n</tbody>\n</table>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions: parser.Tables | parser.Attributes,
+		RendererOptions: html.RendererOptions{
+			TableClass:     "table table-striped",
+			TableHeadClass: "thead-dark",
+			TableBodyClass: "body",
+		},
+	})
+
+	tests = []string{
+		"| a |\n|---|\n| 1 |\n",
+		"<table class=\"table\">\n<thead>\n<tr>\n<th>a</th>\n</tr>\n</thead>\n\n" +
+			"<tbody>\n<tr>\n<td>1</td>\n</tr>\n</tbody>\n</table>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions:      parser.Tables,
+		RendererOptions: html.RendererOptions{TableClass: "table"},
+	})
+}
+
 func TestUnorderedListWith_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK(t *testing.T) {
 	tests := readTestFile2(t, "UnorderedListWith_EXTENSION_NO_EMPTY_LINE_BEFORE_BLOCK.tests")
 	doTestsBlock(t, tests, parser.NoEmptyLineBeforeBlock)
@@ -183,6 +688,26 @@ func TestListWithFencedCodeBlockAndHeader(t *testing.T) {
 	doTestsBlock(t, tests, parser.FencedCode)
 }
 
+func TestDefaultCodeLanguage(t *testing.T) {
+	tests := []string{
+		"    fmt.Println(\"indented\")\n",
+		"<pre><code class=\"language-go\">fmt.Println(&quot;indented&quot;)\n</code></pre>\n",
+
+		"```python\nprint(1)\n```\n",
+		"<pre><code class=\"language-python\">print(1)\n</code></pre>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		extensions:    parser.FencedCode,
+		parserOptions: parser.Options{DefaultCodeLanguage: "go"},
+	})
+
+	tests = []string{
+		"    fmt.Println(\"indented\")\n",
+		"<pre><code>fmt.Println(&quot;indented&quot;)\n</code></pre>\n",
+	}
+	doTestsParam(t, tests, TestParams{extensions: parser.FencedCode})
+}
+
 func TestTitleBlock_EXTENSION_TITLEBLOCK(t *testing.T) {
 	tests := readTestFile2(t, "TitleBlock_EXTENSION_TITLEBLOCK.tests")
 	doTestsBlock(t, tests, parser.Titleblock)
@@ -200,11 +725,41 @@ func TestTOC(t *testing.T) {
 	})
 }
 
+func TestTOCMarker(t *testing.T) {
+	tests := readTestFile2(t, "TOCMarker.tests")
+	doTestsParam(t, tests, TestParams{
+		Flags: html.UseXHTML | html.TOCMarker,
+	})
+}
+
 func TestCompletePage(t *testing.T) {
 	tests := readTestFile2(t, "CompletePage.tests")
 	doTestsParam(t, tests, TestParams{Flags: html.UseXHTML | html.CompletePage})
 }
 
+func TestCompletePageOptions(t *testing.T) {
+	opts := html.RendererOptions{
+		Title: "A & B",
+		CSS:   "/style.css",
+	}
+	tests := []string{
+		"*foo*\n",
+		"<!DOCTYPE html>\n<html>\n<head>\n  <title>A &amp; B</title>\n" +
+			"  <meta name=\"GENERATOR\" content=\"github.com/gomarkdown/markdown markdown processor for Go\">\n" +
+			"  <meta charset=\"utf-8\">\n" +
+			"  <link rel=\"stylesheet\" type=\"text/css\" href=\"/style.css\">\n" +
+			"</head>\n<body>\n\n<p><em>foo</em></p>\n\n</body>\n</html>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.CompletePage, RendererOptions: opts})
+
+	// a fragment ignores the page options
+	tests = []string{
+		"*foo*\n",
+		"<p><em>foo</em></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{RendererOptions: opts})
+}
+
 func TestSpaceHeadings(t *testing.T) {
 	tests := readTestFile2(t, "SpaceHeadings.tests")
 	doTestsParam(t, tests, TestParams{extensions: parser.SpaceHeadings})
@@ -221,3 +776,28 @@ func TestLists(t *testing.T) {
 	exts := parser.CommonExtensions
 	doTestsParam(t, tests, TestParams{extensions: exts})
 }
+
+func TestNoTrailingNewline(t *testing.T) {
+	inputs := []string{
+		"# Heading",
+		"Heading\n=======",
+		"Heading\n-------",
+		"* item",
+		"1. item",
+		"```\ncode\n```",
+		"```go\ncode",
+		"    code",
+		"> quote",
+		"<div>\nblock\n</div>",
+		"| a | b |\n|---|---|\n| 1 | 2 |",
+	}
+	var tests []string
+	for _, input := range inputs {
+		exp := runMarkdown(input+"\n", TestParams{
+			extensions: parser.CommonExtensions,
+			Flags:      html.UseXHTML,
+		})
+		tests = append(tests, input, exp)
+	}
+	doTestsBlock(t, tests, parser.CommonExtensions)
+}
@@ -3,6 +3,9 @@ package markdown
 import (
 	"testing"
 	"time"
+
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
 )
 
 // crashes found with go-fuzz
@@ -28,6 +31,21 @@ func TestCrash1(t *testing.T) {
 	}
 }
 
+// crashes found with FuzzToHTML
+
+func TestCrash2(t *testing.T) {
+	tests := []string{
+		"[@]",
+		"[@, p. 23]",
+		"[@ref;]",
+		"[;@ref]",
+	}
+	for _, test := range tests {
+		p := parser.NewWithExtensions(parser.CommonExtensions | parser.Mmark)
+		ToHTML([]byte(test), p, nil)
+	}
+}
+
 func parseWithShortTimeout(t *testing.T, test string) {
 	c := make(chan bool, 1)
 	go func() {
@@ -54,3 +72,62 @@ func TestInfinite3(t *testing.T) {
 	test := "\xa2 \n\t: \n: "
 	parseWithShortTimeout(t, test)
 }
+
+// every input must be consumed, the paragraph parser is the fallback and
+// must never consume nothing
+func TestSingleCharInputs(t *testing.T) {
+	var tests []string
+	for c := 0; c < 256; c++ {
+		tests = append(tests, string([]byte{byte(c)}), string([]byte{byte(c), '\n'}), "a\n"+string([]byte{byte(c)}))
+	}
+	tests = append(tests, "{\\}", "{.a}", "{#a}\n")
+	allExtensions := ^parser.Extensions(0) &^ parser.Includes
+	c := make(chan bool, 1)
+	go func() {
+		for _, exts := range []parser.Extensions{parser.NoExtensions, parser.CommonExtensions, allExtensions} {
+			for _, test := range tests {
+				p := parser.NewWithExtensions(exts)
+				ToHTML([]byte(test), p, nil)
+			}
+		}
+		c <- true
+	}()
+	select {
+	case <-c:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out parsing single characters")
+	}
+}
+
+func FuzzToHTML(f *testing.F) {
+	seeds := []string{
+		"",
+		"a **",
+		"*",
+		"~~",
+		"[]:<",
+		"<div>\n<div>\n</div>\n",
+		"| a | b |\n|---|---|\n| c | d |\n",
+		"* a\n  * b\n\n1. c\n",
+		"```go\ncode\n```\n",
+		"[link](/url \"title\") ![img](/img) <http://example.com>",
+		"Term\n: definition\n",
+		"text[^1]\n\n[^1]: note\n",
+		"> quote\n> > nested\n",
+	}
+	for _, s := range seeds {
+		f.Add([]byte(s), uint16(0))
+		f.Add([]byte(s), uint16(len(s)))
+	}
+	// every extension, and a small step limit to also stop parsing at
+	// arbitrary points, 0 is no limit
+	exts := ^parser.Extensions(0) &^ parser.Includes
+	f.Fuzz(func(t *testing.T, data []byte, maxSteps uint16) {
+		p := parser.NewWithExtensions(exts)
+		p.Opts.MaxSteps = int(maxSteps)
+		opts := html.RendererOptions{
+			Flags: html.CommonFlags | html.TOC | html.FootnoteReturnLinks | html.Safelink,
+		}
+		ToHTML(data, p, html.NewRenderer(opts))
+	})
+}
@@ -0,0 +1,60 @@
+package markdown
+
+import (
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// Heading is a heading of a markdown document, as returned by Headings.
+type Heading struct {
+	Level int    // 1 for # headings, 2 for ## headings etc.
+	Text  string // text of the heading without markup
+	ID    string // id of the heading in the HTML output
+}
+
+// Headings returns the headings of a markdown document in order, e.g. to
+// build a table of contents or a sidebar. The IDs are those given to the
+// headings by html.Renderer when the document is parsed with
+// parser.AutoHeadingIDs: explicit {#id} ids or slugs of the heading text,
+// with -1, -2 etc. added to duplicates.
+func Headings(input []byte) []Heading {
+	p := parser.NewWithExtensions(parser.CommonExtensions | parser.AutoHeadingIDs)
+	doc := p.Parse(input)
+
+	var headings []Heading
+	ids := map[string]int{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering || heading.IsTitleblock {
+			return ast.GoToNext
+		}
+		h := Heading{
+			Level: heading.Level,
+			Text:  plainText(heading),
+		}
+		if heading.HeadingID != "" {
+			h.ID = html.UniqueHeadingID(ids, heading.HeadingID)
+		}
+		headings = append(headings, h)
+		return ast.SkipChildren
+	})
+	return headings
+}
+
+// plainText returns the text of node and its children without markup.
+func plainText(node ast.Node) string {
+	var text []byte
+	ast.WalkFunc(node, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Text:
+			text = append(text, n.Literal...)
+		case *ast.Code:
+			text = append(text, n.Literal...)
+		case *ast.Softbreak, *ast.Hardbreak:
+			text = append(text, ' ')
+		}
+		return ast.GoToNext
+	})
+	return string(text)
+}
@@ -0,0 +1,52 @@
+package markdown
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gomarkdown/markdown/parser"
+)
+
+func TestHeadings(t *testing.T) {
+	input := []byte(`# Intro
+
+Some text.
+
+## Getting *started*
+
+### Install ` + "`go get`" + `
+
+## Usage {#use}
+
+## Getting started
+
+Title
+-----
+`)
+	want := []Heading{
+		{1, "Intro", "intro"},
+		{2, "Getting started", "getting-started"},
+		{3, "Install go get", "install-go-get"},
+		{2, "Usage", "use"},
+		{2, "Getting started", "getting-started-1"},
+		{2, "Title", "title"},
+	}
+	got := Headings(input)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// the ids match those of the HTML output
+	p := parser.NewWithExtensions(parser.CommonExtensions | parser.AutoHeadingIDs)
+	out := string(ToHTML(input, p, nil))
+	for _, h := range got {
+		if !strings.Contains(out, `id="`+h.ID+`"`) {
+			t.Errorf("id %q not in %s", h.ID, out)
+		}
+	}
+
+	if got := Headings(nil); got != nil {
+		t.Errorf("got %+v for empty input", got)
+	}
+}
@@ -14,6 +14,8 @@ import (
 type TestParams struct {
 	extensions        parser.Extensions
 	referenceOverride parser.ReferenceOverrideFunc
+	missingRef        parser.MissingReferenceFunc
+	parserOptions     parser.Options
 	html.Flags
 	html.RendererOptions
 }
@@ -22,6 +24,8 @@ func runMarkdown(input string, params TestParams) string {
 	params.RendererOptions.Flags = params.Flags
 	parser := parser.NewWithExtensions(params.extensions)
 	parser.ReferenceOverride = params.referenceOverride
+	parser.OnMissingRef = params.missingRef
+	parser.Opts = params.parserOptions
 	renderer := html.NewRenderer(params.RendererOptions)
 
 	d := ToHTML([]byte(input), parser, renderer)
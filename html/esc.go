@@ -14,10 +14,16 @@ var Escaper = [256][]byte{
 
 // EscapeHTML writes html-escaped d to w. It escapes &, <, > and " characters.
 func EscapeHTML(w io.Writer, d []byte) {
+	escapeWith(w, d, &Escaper)
+}
+
+// escapeWith writes d to w, replacing the characters that have an entry in
+// escaper by it.
+func escapeWith(w io.Writer, d []byte, escaper *[256][]byte) {
 	var start, end int
 	n := len(d)
 	for end < n {
-		escSeq := Escaper[d[end]]
+		escSeq := escaper[d[end]]
 		if escSeq != nil {
 			w.Write(d[start:end])
 			w.Write(escSeq)
@@ -30,11 +36,63 @@ func EscapeHTML(w io.Writer, d []byte) {
 	}
 }
 
-func escLink(w io.Writer, text []byte) {
+func escLink(w io.Writer, text []byte, escaper *[256][]byte) {
 	unesc := html.UnescapeString(string(text))
+	escapeWith(w, []byte(unesc), escaper)
+}
+
+// escURL is like escLink but also percent-encodes the characters that are
+// not allowed in URLs, e.g. a space becomes %20, if encode is true.
+func escURL(w io.Writer, url []byte, encode bool) {
+	unesc := html.UnescapeString(string(url))
+	if encode {
+		unesc = percentEncode(unesc)
+	}
 	EscapeHTML(w, []byte(unesc))
 }
 
+// percentEncode percent-encodes spaces, control characters, non-ASCII
+// characters and the ASCII characters that are never allowed in URLs.
+// Existing %XX escapes are left alone.
+func percentEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var buf []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if urlSafe(c) || c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			if buf != nil {
+				buf = append(buf, c)
+			}
+			continue
+		}
+		if buf == nil {
+			buf = append(make([]byte, 0, len(s)+8), s[:i]...)
+		}
+		buf = append(buf, '%', hex[c>>4], hex[c&15])
+	}
+	if buf == nil {
+		return s
+	}
+	return string(buf)
+}
+
+// urlSafe returns true for the characters that can appear in URLs as is:
+// letters, digits and the reserved and unreserved characters of RFC 3986.
+func urlSafe(c byte) bool {
+	if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+		return true
+	}
+	switch c {
+	case '-', '.', '_', '~', ':', '/', '?', '#', '[', ']', '@', '!', '$', '&', '\'', '(', ')', '*', '+', ',', ';', '=':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
 // Escape writes the text to w, but skips the escape character.
 func Escape(w io.Writer, text []byte) {
 	esc := false
@@ -29,7 +29,7 @@ const (
 	NofollowLinks                             // Only link with rel="nofollow"
 	NoreferrerLinks                           // Only link with rel="noreferrer"
 	HrefTargetBlank                           // Add a blank target
-	CompletePage                              // Generate a complete HTML page
+	CompletePage                              // Generate a complete HTML page instead of a fragment
 	UseXHTML                                  // Generate XHTML output instead of HTML
 	FootnoteReturnLinks                       // Generate a link at the end of a footnote to return to the source
 	FootnoteNoHRTag                           // Do not output an HR after starting a footnote list.
@@ -40,8 +40,31 @@ const (
 	SmartypantsAngledQuotes                   // Enable angled double quotes (with Smartypants) for double quotes rendering
 	SmartypantsQuotesNBSP                     // Enable « French guillemets » (with Smartypants)
 	TOC                                       // Generate a table of contents
-
-	CommonFlags Flags = Smartypants | SmartypantsFractions | SmartypantsDashes | SmartypantsLatexDashes
+	SanitizeHTML                              // Keep raw HTML but strip tags and attributes not in AllowedHTML
+	ImageFigures                              // Render a titled image alone in a paragraph as a figure with caption
+	HeadingAnchors                            // Add a permalink anchor to headings that have an ID
+	TOCMarker                                 // Generate a table of contents in place of a [TOC] paragraph
+	NumberHeadings                            // Prefix headings with section numbers like 1.2.1
+	NewlineBreaks                             // Render soft line breaks as <br>, like GitHub does
+	SkipInlineHTML                            // Skip raw HTML tags inside paragraphs, headings etc.
+	EscapeInlineHTML                          // Escape raw HTML tags inside paragraphs, headings etc. so they show literally
+	AccessibleTables                          // Add role="table" to tables and scope="col" to header cells
+	LazyImages                                // Add loading="lazy" to images
+	AsyncImages                               // Add decoding="async" to images
+	PercentEncodeURLs                         // Percent-encode spaces and other characters not allowed in link and image URLs
+	CodeLineNumbers                           // Wrap each line of code blocks in a <span class="line"> with its line number
+	OrderedListValues                         // Add value="N" to ordered list items not numbered in sequence, to keep the numbers written
+	PresentationalEmphasis                    // Render emphasis as <i> and strong emphasis as <b> instead of <em> and <strong>
+	SkipHTMLComments                          // Strip <!-- --> comments from HTML blocks and inline HTML
+	TightBlockQuotes                          // Don't wrap the content of a blockquote holding a single paragraph in <p>
+	StrictXHTML                               // Generate well-formed XML, like UseXHTML but also rewriting raw HTML and entities
+	ChapterSections                           // Wrap each top-level heading of the highest level and what follows it in a <section>
+	EPUB                                      // Generate EPUB 3 XHTML content documents, implies StrictXHTML and ChapterSections
+	CollapseWhitespace                        // Collapse runs of spaces, tabs and line breaks in text to a single space, like browsers do
+
+	// CommonFlags are the flags used by default, e.g. by markdown.ToHTML:
+	// smart punctuation and percent-encoded link and image URLs.
+	CommonFlags Flags = Smartypants | SmartypantsFractions | SmartypantsDashes | SmartypantsLatexDashes | PercentEncodeURLs
 )
 
 var (
@@ -73,6 +96,19 @@ const (
 // skip rendering this node and will return WalkStatus
 type RenderNodeFunc func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool)
 
+// CodeBlockFunc renders a code block instead of the default <pre><code>,
+// see RendererOptions.CodeBlockRenderers.
+type CodeBlockFunc func(w io.Writer, codeBlock *ast.CodeBlock)
+
+// KbdFunc renders a keyboard key instead of the default <kbd>, see
+// RendererOptions.KbdRenderer.
+type KbdFunc func(w io.Writer, key []byte)
+
+// URLRewriteFunc allows rewriting or validating the URL of every link and
+// image before it is written out. isImage tells if url is the source of an
+// image. Returning an empty or nil URL drops the href or src attribute.
+type URLRewriteFunc func(url []byte, isImage bool) []byte
+
 // RendererOptions is a collection of supplementary parameters tweaking
 // the behavior of various parts of HTML renderer.
 type RendererOptions struct {
@@ -80,6 +116,11 @@ type RendererOptions struct {
 	AbsolutePrefix string
 	// Add this text to each footnote anchor, to ensure uniqueness.
 	FootnoteAnchorPrefix string
+	// Start the ids of footnotes and of footnote references with this text,
+	// followed by a colon, e.g. to avoid collisions when several rendered
+	// documents share a page. If blank, fn and fnref are used.
+	FootnoteIDPrefix    string
+	FootnoteRefIDPrefix string
 	// Show this text inside the <a> tag for a footnote return link, if the
 	// FootnoteReturnLinks flag is enabled. If blank, the string
 	// <sup>[return]</sup> is used.
@@ -91,11 +132,39 @@ type RendererOptions struct {
 	HeadingIDPrefix string
 	// If set, add this text to the back of each Heading ID, to ensure uniqueness.
 	HeadingIDSuffix string
-
-	Title string // Document title (used if CompletePage is set)
-	CSS   string // Optional CSS file URL (used if CompletePage is set)
-	Icon  string // Optional icon file URL (used if CompletePage is set)
-	Head  []byte // Optional head data injected in the <head> section (used if CompletePage is set)
+	// Text of the permalink anchor added to headings with the HeadingAnchors
+	// flag. If blank, the string ¶ is used.
+	HeadingAnchorText string
+	// Class of the permalink anchor added to headings with the HeadingAnchors
+	// flag. If blank, the string anchor is used.
+	HeadingAnchorClass string
+	// If set, shift the level of all headings so the shallowest one is
+	// rendered at this level, e.g. 2 to embed a document whose top headings
+	// are # as <h2>. Levels are kept between 1 and 6.
+	MinHeadingLevel int
+	// If set, add this class to each <hr> tag, e.g. "separator".
+	HorizontalRuleClass string
+	// If set, output this markup instead of the <hr> tag for horizontal
+	// rules, e.g. `<div class="divider"></div>`. It is written as is.
+	HorizontalRule string
+	// If set, add type="..." with this numbering style to the <ol> tags of
+	// ordered lists: "a" or "A" for letters, "i" or "I" for roman numerals.
+	// The list of footnotes is left alone.
+	OrderedListType string
+	// If set, add these classes to the <table>, <thead> and <tbody> tags of
+	// tables, e.g. "table table-striped" for Bootstrap.
+	TableClass     string
+	TableHeadClass string
+	TableBodyClass string
+
+	// The options below are only used for complete pages, when the
+	// CompletePage flag is set. By default the renderer outputs a fragment
+	// to embed in a page, without <html>, <head> or <body>, and they are
+	// ignored.
+	Title string // Document title, the <title> is empty if not set
+	CSS   string // Optional CSS file URL
+	Icon  string // Optional icon file URL
+	Head  []byte // Optional head data injected in the <head> section
 
 	Flags Flags // Flags allow customizing this renderer's behavior
 
@@ -107,6 +176,42 @@ type RendererOptions struct {
 	// parsing code blocks and detecting callouts.
 	Comments [][]byte
 
+	// CodeBlockRenderers maps the language of fenced code blocks to the
+	// function rendering them, e.g. to output mermaid diagrams as
+	// <div class="mermaid">. Code blocks in other languages are rendered as
+	// <pre><code>.
+	CodeBlockRenderers map[string]CodeBlockFunc
+
+	// KbdRenderer, if set, renders the keyboard keys of the Keyboard
+	// extension instead of <kbd>key</kbd>, e.g. as an icon for arrow keys.
+	KbdRenderer KbdFunc
+
+	// PostProcess, if set, is called once with the whole output, after the
+	// footer, by markdown.Render, markdown.RenderTo, markdown.ToHTML and
+	// their Context and WithLimits variants, which return or write what it
+	// returns instead. Allows e.g. minifying
+	// the output or replacing tokens in it. RenderTo then only writes the
+	// output when it's complete.
+	PostProcess func(output []byte) []byte
+
+	// if set, called with the destination of every link and image. Allows
+	// e.g. proxying images or rewriting links in one place
+	URLRewriter URLRewriteFunc
+
+	// AllowedHTML maps tag names to their allowed attributes when the
+	// SanitizeHTML flag is set. If nil, DefaultAllowedHTML is used.
+	AllowedHTML map[string][]string
+
+	// SafeSchemes lists additional URL schemes, without the colon, that are
+	// considered safe when the Safelink flag is set, e.g. "tel". Relative
+	// links and http, https, ftp and mailto links are always safe.
+	SafeSchemes []string
+
+	// TextEntities maps characters to the entity they are escaped as in the
+	// text of the document, in addition to &, <, > and ", e.g. '\'' to
+	// "&#39;" for some XML targets.
+	TextEntities map[byte]string
+
 	// Generator is a meta tag that is inserted in the generated HTML so show what rendered it. It should not include the closing tag.
 	// Defaults (note content quote is not closed) to `  <meta name="GENERATOR" content="github.com/gomarkdown/markdown markdown processor for Go`
 	Generator string
@@ -123,9 +228,40 @@ type Renderer struct {
 	// Track heading IDs to prevent ID collision in a single generation.
 	headingIDs map[string]int
 
+	// number of references to each footnote by slug in the document and
+	// rendered so far, the second and later references get a :2, :3 etc.
+	// suffix to keep their ids unique
+	footnoteRefs         map[string]int
+	renderedFootnoteRefs map[string]int
+
 	lastOutputLen int
 	disableTags   int
 
+	// id of the heading being rendered, used for HeadingAnchors
+	headingID string
+
+	// table of contents to render at the [TOC] marker, used for TOCMarker
+	toc []byte
+
+	// levels and counters of the enclosing headings, used for NumberHeadings
+	headingLevels  []int
+	headingNumbers []int
+
+	// added to heading levels, used for MinHeadingLevel
+	headingShift int
+
+	// inline HTML tags escaped to keep the output well-formed, used for
+	// StrictXHTML
+	unbalancedHTML map[*ast.HTMLSpan]bool
+
+	// level of the headings starting chapters and whether a chapter
+	// <section> is open, used for ChapterSections
+	chapterLevel int
+	inChapter    bool
+
+	// Escaper with the TextEntities, used for text
+	textEscaper *[256][]byte
+
 	sr *SPRenderer
 
 	documentMatter ast.DocumentMatters // keep track of front/main/back matter.
@@ -135,6 +271,12 @@ type Renderer struct {
 // satisfies the Renderer interface.
 func NewRenderer(opts RendererOptions) *Renderer {
 	// configure the rendering engine
+	if opts.Flags&EPUB != 0 {
+		opts.Flags |= StrictXHTML | ChapterSections
+	}
+	if opts.Flags&StrictXHTML != 0 {
+		opts.Flags |= UseXHTML
+	}
 	closeTag := ">"
 	if opts.Flags&UseXHTML != 0 {
 		closeTag = " />"
@@ -146,15 +288,33 @@ func NewRenderer(opts RendererOptions) *Renderer {
 	if opts.CitationFormatString == "" {
 		opts.CitationFormatString = `<sup>[%s]</sup>`
 	}
+	if opts.FootnoteIDPrefix == "" {
+		opts.FootnoteIDPrefix = "fn"
+	}
+	if opts.FootnoteRefIDPrefix == "" {
+		opts.FootnoteRefIDPrefix = "fnref"
+	}
 	if opts.Generator == "" {
 		opts.Generator = `  <meta name="GENERATOR" content="github.com/gomarkdown/markdown markdown processor for Go`
 	}
 
+	textEscaper := &Escaper
+	if len(opts.TextEntities) > 0 {
+		entities := Escaper
+		for c, entity := range opts.TextEntities {
+			entities[c] = []byte(entity)
+		}
+		textEscaper = &entities
+	}
+
 	return &Renderer{
 		opts: opts,
 
-		closeTag:   closeTag,
-		headingIDs: make(map[string]int),
+		closeTag:             closeTag,
+		headingIDs:           make(map[string]int),
+		footnoteRefs:         make(map[string]int),
+		renderedFootnoteRefs: make(map[string]int),
+		textEscaper:          textEscaper,
 
 		sr: NewSmartypantsRenderer(opts.Flags),
 	}
@@ -254,19 +414,26 @@ func isRelativeLink(link []byte) (yes bool) {
 }
 
 func (r *Renderer) ensureUniqueHeadingID(id string) string {
-	for count, found := r.headingIDs[id]; found; count, found = r.headingIDs[id] {
+	return UniqueHeadingID(r.headingIDs, id)
+}
+
+// UniqueHeadingID returns id, or id with a -1, -2 etc. suffix if it's already
+// used, and records it in ids, the ids used so far in a document. It's how the
+// renderer keeps the ids of headings unique.
+func UniqueHeadingID(ids map[string]int, id string) string {
+	for count, found := ids[id]; found; count, found = ids[id] {
 		tmp := fmt.Sprintf("%s-%d", id, count+1)
 
-		if _, tmpFound := r.headingIDs[tmp]; !tmpFound {
-			r.headingIDs[id] = count + 1
+		if _, tmpFound := ids[tmp]; !tmpFound {
+			ids[id] = count + 1
 			id = tmp
 		} else {
 			id = id + "-1"
 		}
 	}
 
-	if _, found := r.headingIDs[id]; !found {
-		r.headingIDs[id] = 0
+	if _, found := ids[id]; !found {
+		ids[id] = 0
 	}
 
 	return id
@@ -309,11 +476,23 @@ func isMailto(link []byte) bool {
 	return bytes.HasPrefix(link, []byte("mailto:"))
 }
 
-func needSkipLink(flags Flags, dest []byte) bool {
+func needSkipLink(flags Flags, dest []byte, schemes []string) bool {
 	if flags&SkipLinks != 0 {
 		return true
 	}
-	return flags&Safelink != 0 && !isSafeLink(dest) && !isMailto(dest)
+	return flags&Safelink != 0 && !isSafeLink(dest) && !isMailto(dest) && !hasScheme(dest, schemes)
+}
+
+// hasScheme returns true if link starts with one of the schemes followed by a
+// colon and a non-empty remainder. The scheme is matched case-insensitively.
+func hasScheme(link []byte, schemes []string) bool {
+	for _, scheme := range schemes {
+		n := len(scheme)
+		if n > 0 && len(link) > n+1 && link[n] == ':' && bytes.EqualFold(link[:n], []byte(scheme)) {
+			return true
+		}
+	}
+	return false
 }
 
 func isSmartypantable(node ast.Node) bool {
@@ -328,12 +507,18 @@ func appendLanguageAttr(attrs []string, info []byte) []string {
 	if len(info) == 0 {
 		return attrs
 	}
+	s := `class="language-` + codeBlockLanguage(info) + `"`
+	return append(attrs, s)
+}
+
+// codeBlockLanguage returns the language of a fenced code block, the first
+// word of its info string.
+func codeBlockLanguage(info []byte) string {
 	endOfLang := bytes.IndexAny(info, "\t ")
 	if endOfLang < 0 {
 		endOfLang = len(info)
 	}
-	s := `class="language-` + string(info[:endOfLang]) + `"`
-	return append(attrs, s)
+	return string(info[:endOfLang])
 }
 
 func (r *Renderer) outTag(w io.Writer, name string, attrs []string) {
@@ -345,19 +530,52 @@ func (r *Renderer) outTag(w io.Writer, name string, attrs []string) {
 	r.lastOutputLen = 1
 }
 
-func footnoteRef(prefix string, node *ast.Link) string {
-	urlFrag := prefix + string(slugify(node.Destination))
+func (r *Renderer) footnoteRef(node *ast.Link) string {
+	slug := string(slugify(node.Destination))
+	r.renderedFootnoteRefs[slug]++
+	urlFrag := r.opts.FootnoteAnchorPrefix + slug
 	nStr := strconv.Itoa(node.NoteID)
-	anchor := `<a href="#fn:` + urlFrag + `">` + nStr + `</a>`
-	return `<sup class="footnote-ref" id="fnref:` + urlFrag + `">` + anchor + `</sup>`
+	anchor := `<a href="#` + r.opts.FootnoteIDPrefix + ":" + urlFrag + `">` + nStr + `</a>`
+	id := r.footnoteRefID(slug, r.renderedFootnoteRefs[slug])
+	return `<sup class="footnote-ref" id="` + id + `">` + anchor + `</sup>`
+}
+
+// countFootnoteRefs returns the number of references to each footnote of doc
+// by slug.
+func countFootnoteRefs(doc ast.Node) map[string]int {
+	refs := make(map[string]int)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if link, ok := node.(*ast.Link); ok && entering && link.NoteID != 0 {
+			refs[string(slugify(link.Destination))]++
+		}
+		return ast.GoToNext
+	})
+	return refs
+}
+
+// footnoteRefID returns the id of the n-th reference to the footnote slug.
+func (r *Renderer) footnoteRefID(slug string, n int) string {
+	id := r.opts.FootnoteRefIDPrefix + ":" + r.opts.FootnoteAnchorPrefix + slug
+	if n > 1 {
+		id += ":" + strconv.Itoa(n)
+	}
+	return id
 }
 
-func footnoteItem(prefix string, slug []byte) string {
-	return `<li id="fn:` + prefix + string(slug) + `">`
+func (r *Renderer) footnoteItem(slug []byte) string {
+	return `<li id="` + r.opts.FootnoteIDPrefix + ":" + r.opts.FootnoteAnchorPrefix + string(slug) + `">`
 }
 
-func footnoteReturnLink(prefix, returnLink string, slug []byte) string {
-	return ` <a class="footnote-return" href="#fnref:` + prefix + string(slug) + `">` + returnLink + `</a>`
+// footnoteReturnLinks returns a link back to each reference to the footnote
+// slug.
+func (r *Renderer) footnoteReturnLinks(slug []byte) string {
+	var s string
+	n := r.footnoteRefs[string(slug)]
+	for i := 1; i == 1 || i <= n; i++ {
+		href := "#" + r.footnoteRefID(string(slug), i)
+		s += ` <a class="footnote-return" href="` + href + `">` + r.opts.FootnoteReturnLinkContents + `</a>`
+	}
+	return s
 }
 
 func listItemOpenCR(listItem *ast.ListItem) bool {
@@ -380,6 +598,14 @@ func skipParagraphTags(para *ast.Paragraph) bool {
 	return tightOrTerm
 }
 
+// isSingleParagraphQuote returns true if para is the only child of a
+// blockquote, e.g. in "> quote". A quote with several paragraphs always gets
+// a <p> for each.
+func isSingleParagraphQuote(para *ast.Paragraph) bool {
+	quote, ok := para.Parent.(*ast.BlockQuote)
+	return ok && len(quote.Children) == 1
+}
+
 func (r *Renderer) out(w io.Writer, d []byte) {
 	r.lastOutputLen = len(d)
 	if r.disableTags > 0 {
@@ -422,30 +648,110 @@ func headingCloseTagFromLevel(level int) string {
 }
 
 func (r *Renderer) outHRTag(w io.Writer, attrs []string) {
+	if r.opts.HorizontalRule != "" {
+		r.outs(w, r.opts.HorizontalRule)
+		return
+	}
+	if class := r.opts.HorizontalRuleClass; class != "" {
+		attrs = appendClass(attrs, class)
+	}
 	hr := tagWithAttributes("<hr", attrs)
-	r.outOneOf(w, r.opts.Flags&UseXHTML == 0, hr, "<hr />")
+	xhr := "<hr />"
+	if len(attrs) > 0 {
+		xhr = "<hr " + strings.Join(attrs, " ") + " />"
+	}
+	r.outOneOf(w, r.opts.Flags&UseXHTML == 0, hr, xhr)
+}
+
+// appendClass adds class to the class attribute in attrs, adding the
+// attribute if there is none.
+func appendClass(attrs []string, class string) []string {
+	for i, a := range attrs {
+		if strings.HasPrefix(a, `class="`) {
+			attrs[i] = a[:len(a)-1] + " " + class + `"`
+			return attrs
+		}
+	}
+	return append(attrs, `class="`+class+`"`)
 }
 
 func (r *Renderer) text(w io.Writer, text *ast.Text) {
+	literal := text.Literal
+	if r.opts.Flags&CollapseWhitespace != 0 {
+		literal = collapseWhitespace(literal)
+	}
 	if r.opts.Flags&Smartypants != 0 {
 		var tmp bytes.Buffer
-		EscapeHTML(&tmp, text.Literal)
-		r.sr.Process(w, tmp.Bytes())
+		escapeWith(&tmp, literal, r.textEscaper)
+		r.smartypants(w, tmp.Bytes())
 	} else {
 		_, parentIsLink := text.Parent.(*ast.Link)
 		if parentIsLink {
-			escLink(w, text.Literal)
+			escLink(w, literal, r.textEscaper)
 		} else {
-			EscapeHTML(w, text.Literal)
+			escapeWith(w, literal, r.textEscaper)
 		}
 	}
 }
 
+// collapseWhitespace returns d with each run of spaces, tabs and newlines
+// replaced by a single space.
+func collapseWhitespace(d []byte) []byte {
+	var out []byte
+	for i := 0; i < len(d); i++ {
+		if !isSpace(d[i]) {
+			if out != nil {
+				out = append(out, d[i])
+			}
+			continue
+		}
+		end := i + 1
+		for end < len(d) && isSpace(d[end]) {
+			end++
+		}
+		if d[i] == ' ' && end == i+1 && out == nil {
+			continue
+		}
+		if out == nil {
+			out = append(make([]byte, 0, len(d)), d[:i]...)
+		}
+		out = append(out, ' ')
+		i = end - 1
+	}
+	if out == nil {
+		return d
+	}
+	return out
+}
+
+// smartypants writes text with smart punctuation. The entities it uses,
+// e.g. &rsquo;, are not defined in XML, so they are made numeric with
+// StrictXHTML.
+func (r *Renderer) smartypants(w io.Writer, text []byte) {
+	if r.opts.Flags&StrictXHTML == 0 {
+		r.sr.Process(w, text)
+		return
+	}
+	var tmp bytes.Buffer
+	r.sr.Process(&tmp, text)
+	w.Write(xmlEntities(tmp.Bytes()))
+}
+
 func (r *Renderer) hardBreak(w io.Writer, node *ast.Hardbreak) {
 	r.outOneOf(w, r.opts.Flags&UseXHTML == 0, "<br>", "<br />")
 	r.cr(w)
 }
 
+func (r *Renderer) softBreak(w io.Writer, node *ast.Softbreak) {
+	if r.opts.Flags&NewlineBreaks != 0 {
+		r.outOneOf(w, r.opts.Flags&UseXHTML == 0, "<br>", "<br />")
+	} else if r.opts.Flags&CollapseWhitespace != 0 {
+		r.outs(w, " ")
+		return
+	}
+	r.outs(w, "\n")
+}
+
 func (r *Renderer) outOneOf(w io.Writer, outFirst bool, first string, second string) {
 	if outFirst {
 		r.outs(w, first)
@@ -465,26 +771,66 @@ func (r *Renderer) outOneOfCr(w io.Writer, outFirst bool, first string, second s
 }
 
 func (r *Renderer) htmlSpan(w io.Writer, span *ast.HTMLSpan) {
-	if r.opts.Flags&SkipHTML == 0 {
-		r.out(w, span.Literal)
+	switch {
+	case r.opts.Flags&SkipHTMLComments != 0 && len(stripHTMLComments(span.Literal)) == 0:
+		// skip it
+	case r.opts.Flags&SkipInlineHTML != 0:
+		// skip it
+	case r.opts.Flags&EscapeInlineHTML != 0 || r.unbalancedHTML[span]:
+		EscapeHTML(w, span.Literal)
+	case r.opts.Flags&SkipHTML == 0:
+		r.out(w, r.rawHTML(span.Literal))
+	}
+}
+
+// rawHTML returns raw HTML sanitized, and rewritten as XHTML if the
+// StrictXHTML flag is set.
+func (r *Renderer) rawHTML(d []byte) []byte {
+	d = r.sanitize(d)
+	if r.opts.Flags&StrictXHTML != 0 {
+		d = xhtmlTags(d)
 	}
+	return d
+}
+
+// sanitize returns raw HTML cleaned up according to AllowedHTML if the
+// SanitizeHTML flag is set, or as is otherwise.
+func (r *Renderer) sanitize(d []byte) []byte {
+	if r.opts.Flags&SanitizeHTML == 0 {
+		return d
+	}
+	allowed := r.opts.AllowedHTML
+	if allowed == nil {
+		allowed = DefaultAllowedHTML
+	}
+	return sanitizeHTML(d, allowed)
+}
+
+func (r *Renderer) rewriteURL(url []byte, isImage bool) []byte {
+	if r.opts.URLRewriter == nil {
+		return url
+	}
+	return r.opts.URLRewriter(url, isImage)
 }
 
 func (r *Renderer) linkEnter(w io.Writer, link *ast.Link) {
-	var attrs []string
-	dest := link.Destination
-	dest = r.addAbsPrefix(dest)
-	var hrefBuf bytes.Buffer
-	hrefBuf.WriteString("href=\"")
-	escLink(&hrefBuf, dest)
-	hrefBuf.WriteByte('"')
-	attrs = append(attrs, hrefBuf.String())
 	if link.NoteID != 0 {
-		r.outs(w, footnoteRef(r.opts.FootnoteAnchorPrefix, link))
+		r.outs(w, r.footnoteRef(link))
 		return
 	}
 
-	attrs = appendLinkAttrs(attrs, r.opts.Flags, dest)
+	var attrs []string
+	dest := link.Destination
+	dest = r.addAbsPrefix(dest)
+	dest = r.rewriteURL(dest, false)
+	if len(dest) > 0 {
+		var hrefBuf bytes.Buffer
+		hrefBuf.WriteString("href=\"")
+		escURL(&hrefBuf, dest, r.opts.Flags&PercentEncodeURLs != 0)
+		hrefBuf.WriteByte('"')
+		attrs = append(attrs, hrefBuf.String())
+		attrs = appendLinkAttrs(attrs, r.opts.Flags, dest)
+	}
 	if len(link.Title) > 0 {
 		var titleBuff bytes.Buffer
 		titleBuff.WriteString("title=\"")
@@ -503,7 +849,7 @@ func (r *Renderer) linkExit(w io.Writer, link *ast.Link) {
 
 func (r *Renderer) link(w io.Writer, link *ast.Link, entering bool) {
 	// mark it but don't link it if it is not a safe link: no smartypants
-	if needSkipLink(r.opts.Flags, link.Destination) {
+	if needSkipLink(r.opts.Flags, link.Destination, r.opts.SafeSchemes) {
 		r.outOneOf(w, entering, "<tt>", "</tt>")
 		return
 	}
@@ -518,13 +864,18 @@ func (r *Renderer) link(w io.Writer, link *ast.Link, entering bool) {
 func (r *Renderer) imageEnter(w io.Writer, image *ast.Image) {
 	dest := image.Destination
 	dest = r.addAbsPrefix(dest)
+	dest = r.rewriteURL(dest, true)
 	if r.disableTags == 0 {
 		//if options.safe && potentiallyUnsafe(dest) {
 		//out(w, `<img src="" alt="`)
 		//} else {
-		r.outs(w, `<img src="`)
-		escLink(w, dest)
-		r.outs(w, `" alt="`)
+		if len(dest) > 0 {
+			r.outs(w, `<img src="`)
+			escURL(w, dest, r.opts.Flags&PercentEncodeURLs != 0)
+			r.outs(w, `" alt="`)
+		} else {
+			r.outs(w, `<img alt="`)
+		}
 		//}
 	}
 	r.disableTags++
@@ -537,7 +888,20 @@ func (r *Renderer) imageExit(w io.Writer, image *ast.Image) {
 			r.outs(w, `" title="`)
 			EscapeHTML(w, image.Title)
 		}
-		r.outs(w, `" />`)
+		r.outs(w, `"`)
+		if image.Width > 0 {
+			r.outs(w, fmt.Sprintf(` width="%d"`, image.Width))
+		}
+		if image.Height > 0 {
+			r.outs(w, fmt.Sprintf(` height="%d"`, image.Height))
+		}
+		if r.opts.Flags&LazyImages != 0 {
+			r.outs(w, ` loading="lazy"`)
+		}
+		if r.opts.Flags&AsyncImages != 0 {
+			r.outs(w, ` decoding="async"`)
+		}
+		r.outs(w, ` />`)
 	}
 }
 
@@ -563,12 +927,45 @@ func (r *Renderer) paragraphEnter(w io.Writer, para *ast.Paragraph) {
 		}
 	}
 
+	if r.figureImage(para) != nil {
+		r.outs(w, tagWithAttributes("<figure", BlockAttrs(para)))
+		return
+	}
 	tag := tagWithAttributes("<p", BlockAttrs(para))
 	r.outs(w, tag)
 }
 
+// figureImage returns the image if para is to be rendered as a figure, i.e.
+// when the ImageFigures flag is set and para only holds an image with a title.
+func (r *Renderer) figureImage(para *ast.Paragraph) *ast.Image {
+	if r.opts.Flags&ImageFigures == 0 || r.opts.Flags&SkipImages != 0 {
+		return nil
+	}
+	var img *ast.Image
+	for _, child := range para.GetChildren() {
+		if text, ok := child.(*ast.Text); ok && len(text.Literal) == 0 {
+			continue
+		}
+		if i, ok := child.(*ast.Image); ok && img == nil {
+			img = i
+			continue
+		}
+		return nil
+	}
+	if img == nil || len(img.Title) == 0 {
+		return nil
+	}
+	return img
+}
+
 func (r *Renderer) paragraphExit(w io.Writer, para *ast.Paragraph) {
-	r.outs(w, "</p>")
+	if img := r.figureImage(para); img != nil {
+		r.outs(w, "<figcaption>")
+		EscapeHTML(w, img.Title)
+		r.outs(w, "</figcaption></figure>")
+	} else {
+		r.outs(w, "</p>")
+	}
 	if !(isListItem(para.Parent) && ast.GetNextNode(para) == nil) {
 		r.cr(w)
 	}
@@ -578,6 +975,10 @@ func (r *Renderer) paragraph(w io.Writer, para *ast.Paragraph, entering bool) {
 	if skipParagraphTags(para) {
 		return
 	}
+	if r.opts.Flags&TightBlockQuotes != 0 && isSingleParagraphQuote(para) {
+		r.cr(w)
+		return
+	}
 	if entering {
 		r.paragraphEnter(w, para)
 	} else {
@@ -602,11 +1003,47 @@ func (r *Renderer) htmlBlock(w io.Writer, node *ast.HTMLBlock) {
 	if r.opts.Flags&SkipHTML != 0 {
 		return
 	}
+	html := node.Literal
+	if r.opts.Flags&SkipHTMLComments != 0 {
+		html = stripHTMLComments(html)
+		if len(bytes.TrimSpace(html)) == 0 {
+			return
+		}
+	}
+	html = r.rawHTML(html)
 	r.cr(w)
-	r.out(w, node.Literal)
+	if r.opts.Flags&StrictXHTML != 0 && !isWellFormed(html) {
+		// e.g. an unclosed <div>, which can't be fixed
+		var buf bytes.Buffer
+		EscapeHTML(&buf, html)
+		html = buf.Bytes()
+	}
+	r.out(w, html)
 	r.cr(w)
 }
 
+// stripHTMLComments returns d without its <!-- --> comments. An unclosed
+// comment is kept.
+func stripHTMLComments(d []byte) []byte {
+	var out []byte
+	for {
+		start := bytes.Index(d, []byte("<!--"))
+		if start < 0 {
+			break
+		}
+		end := bytes.Index(d[start+4:], []byte("-->"))
+		if end < 0 {
+			break
+		}
+		out = append(out, d[:start]...)
+		d = d[start+4+end+3:]
+	}
+	if out == nil {
+		return d
+	}
+	return append(out, d...)
+}
+
 func (r *Renderer) headingEnter(w io.Writer, nodeData *ast.Heading) {
 	var attrs []string
 	var class string
@@ -634,27 +1071,175 @@ func (r *Renderer) headingEnter(w io.Writer, nodeData *ast.Heading) {
 		}
 		attrID := `id="` + id + `"`
 		attrs = append(attrs, attrID)
+		r.headingID = id
 	}
 	attrs = append(attrs, BlockAttrs(nodeData)...)
 	r.cr(w)
-	r.outTag(w, headingOpenTagFromLevel(nodeData.Level), attrs)
+	r.outTag(w, headingOpenTagFromLevel(r.headingLevel(nodeData)), attrs)
+	if r.opts.Flags&NumberHeadings != 0 && !nodeData.IsTitleblock && !nodeData.IsSpecial {
+		r.outs(w, r.headingNumber(nodeData.Level)+" ")
+	}
+}
+
+// headingNumber returns the section number of the next heading of the given
+// level, e.g. 1.2.1. Skipped levels don't add a number, so a h3 right after
+// a h1 is numbered 1.1 rather than 1.0.1.
+func (r *Renderer) headingNumber(level int) string {
+	n := len(r.headingLevels)
+	for n > 0 && r.headingLevels[n-1] > level {
+		n--
+	}
+	switch {
+	case n > 0 && r.headingLevels[n-1] == level:
+		r.headingNumbers[n-1]++
+	case n < len(r.headingLevels):
+		// a h2 after a h3 that skipped it continues the numbering of the h3
+		r.headingLevels[n] = level
+		r.headingNumbers[n]++
+		n++
+	default:
+		r.headingLevels = append(r.headingLevels, level)
+		r.headingNumbers = append(r.headingNumbers, 1)
+		n++
+	}
+	r.headingLevels = r.headingLevels[:n]
+	r.headingNumbers = r.headingNumbers[:n]
+	parts := make([]string, len(r.headingNumbers))
+	for i, num := range r.headingNumbers {
+		parts[i] = strconv.Itoa(num)
+	}
+	return strings.Join(parts, ".")
 }
 
 func (r *Renderer) headingExit(w io.Writer, heading *ast.Heading) {
-	r.outs(w, headingCloseTagFromLevel(heading.Level))
+	if r.opts.Flags&HeadingAnchors != 0 && r.headingID != "" {
+		text := r.opts.HeadingAnchorText
+		if text == "" {
+			text = "¶"
+		}
+		class := r.opts.HeadingAnchorClass
+		if class == "" {
+			class = "anchor"
+		}
+		r.outs(w, `<a href="#`+r.headingID+`" class="`+class+`">`+text+`</a>`)
+	}
+	r.headingID = ""
+	r.outs(w, headingCloseTagFromLevel(r.headingLevel(heading)))
 	if !(isListItem(heading.Parent) && ast.GetNextNode(heading) == nil) {
 		r.cr(w)
 	}
 }
 
+// headingLevel returns the level heading is rendered at, shifted for
+// MinHeadingLevel. Title blocks are not shifted.
+func (r *Renderer) headingLevel(heading *ast.Heading) int {
+	if heading.IsTitleblock {
+		return heading.Level
+	}
+	level := heading.Level + r.headingShift
+	if level < 1 {
+		return 1
+	}
+	if level > 6 {
+		return 6
+	}
+	return level
+}
+
+// minHeadingLevel returns the level of the shallowest heading in doc, or 0 if
+// it has no headings.
+func minHeadingLevel(doc ast.Node) int {
+	min := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if heading, ok := node.(*ast.Heading); ok && entering && !heading.IsTitleblock {
+			if min == 0 || heading.Level < min {
+				min = heading.Level
+			}
+		}
+		return ast.GoToNext
+	})
+	return min
+}
+
+// chapterLevel returns the level of the top-level headings of doc starting
+// chapters, the smallest one, or 0 if there are none.
+func chapterLevel(doc ast.Node) int {
+	min := 0
+	for _, child := range doc.GetChildren() {
+		if heading, ok := child.(*ast.Heading); ok && !heading.IsTitleblock {
+			if min == 0 || heading.Level < min {
+				min = heading.Level
+			}
+		}
+	}
+	return min
+}
+
+// isChapterHeading returns true if heading starts a chapter with
+// ChapterSections.
+func (r *Renderer) isChapterHeading(heading *ast.Heading) bool {
+	if r.opts.Flags&ChapterSections == 0 || heading.IsTitleblock || heading.Level != r.chapterLevel {
+		return false
+	}
+	_, ok := heading.Parent.(*ast.Document)
+	return ok
+}
+
+func (r *Renderer) openChapter(w io.Writer) {
+	r.closeChapter(w)
+	r.cr(w)
+	if r.opts.Flags&EPUB != 0 {
+		r.outs(w, `<section epub:type="chapter">`)
+	} else {
+		r.outs(w, "<section>")
+	}
+	r.cr(w)
+	r.inChapter = true
+}
+
+func (r *Renderer) closeChapter(w io.Writer) {
+	if !r.inChapter {
+		return
+	}
+	r.cr(w)
+	r.outs(w, "</section>")
+	r.cr(w)
+	r.inChapter = false
+}
+
 func (r *Renderer) heading(w io.Writer, node *ast.Heading, entering bool) {
 	if entering {
+		if r.isChapterHeading(node) {
+			r.openChapter(w)
+		}
 		r.headingEnter(w, node)
 	} else {
 		r.headingExit(w, node)
 	}
 }
 
+func (r *Renderer) kbd(w io.Writer, kbd *ast.Kbd) {
+	if r.opts.KbdRenderer != nil {
+		r.opts.KbdRenderer(w, kbd.Literal)
+		return
+	}
+	r.outs(w, "<kbd>")
+	EscapeHTML(w, kbd.Literal)
+	r.outs(w, "</kbd>")
+}
+
+func (r *Renderer) abbreviation(w io.Writer, abbr *ast.Abbreviation) {
+	if len(abbr.Title) == 0 {
+		r.outs(w, "<abbr>")
+	} else {
+		r.outs(w, `<abbr title="`)
+		EscapeHTML(w, abbr.Title)
+		r.outs(w, `">`)
+	}
+	EscapeHTML(w, abbr.Literal)
+	r.outs(w, "</abbr>")
+}
+
 func (r *Renderer) horizontalRule(w io.Writer, node *ast.HorizontalRule) {
 	r.cr(w)
 	r.outHRTag(w, BlockAttrs(node))
@@ -666,6 +1251,7 @@ func (r *Renderer) listEnter(w io.Writer, nodeData *ast.List) {
 	var attrs []string
 
 	if nodeData.IsFootnotesList {
+		r.closeChapter(w)
 		r.outs(w, "\n<div class=\"footnotes\">\n\n")
 		if r.opts.Flags&FootnoteNoHRTag == 0 {
 			r.outHRTag(w, nil)
@@ -685,6 +1271,9 @@ func (r *Renderer) listEnter(w io.Writer, nodeData *ast.List) {
 		if nodeData.Start > 0 {
 			attrs = append(attrs, fmt.Sprintf(`start="%d"`, nodeData.Start))
 		}
+		if r.opts.OrderedListType != "" && !nodeData.IsFootnotesList {
+			attrs = append(attrs, `type="`+r.opts.OrderedListType+`"`)
+		}
 		openTag = "<ol"
 	}
 	if nodeData.ListFlags&ast.ListTypeDefinition != 0 {
@@ -738,11 +1327,16 @@ func (r *Renderer) listItemEnter(w io.Writer, listItem *ast.ListItem) {
 	}
 	if listItem.RefLink != nil {
 		slug := slugify(listItem.RefLink)
-		r.outs(w, footnoteItem(r.opts.FootnoteAnchorPrefix, slug))
+		r.outs(w, r.footnoteItem(slug))
 		return
 	}
 
 	openTag := "<li>"
+	if r.opts.Flags&OrderedListValues != 0 && listItem.ListFlags&ast.ListTypeOrdered != 0 {
+		if n := listItem.Number; n > 0 && n != expectedListItemNumber(listItem) {
+			openTag = `<li value="` + strconv.Itoa(n) + `">`
+		}
+	}
 	if listItem.ListFlags&ast.ListTypeDefinition != 0 {
 		openTag = "<dd>"
 	}
@@ -752,13 +1346,22 @@ func (r *Renderer) listItemEnter(w io.Writer, listItem *ast.ListItem) {
 	r.outs(w, openTag)
 }
 
+// expectedListItemNumber returns the number the browser gives to listItem
+// without a value attribute: the start of the list or the number of the
+// previous item plus one.
+func expectedListItemNumber(listItem *ast.ListItem) int {
+	if prev, ok := ast.GetPrevNode(listItem).(*ast.ListItem); ok {
+		return prev.Number + 1
+	}
+	if list, ok := listItem.Parent.(*ast.List); ok && list.Start > 0 {
+		return list.Start
+	}
+	return 1
+}
+
 func (r *Renderer) listItemExit(w io.Writer, listItem *ast.ListItem) {
 	if listItem.RefLink != nil && r.opts.Flags&FootnoteReturnLinks != 0 {
-		slug := slugify(listItem.RefLink)
-		prefix := r.opts.FootnoteAnchorPrefix
-		link := r.opts.FootnoteReturnLinkContents
-		s := footnoteReturnLink(prefix, link, slug)
-		r.outs(w, s)
+		r.outs(w, r.footnoteReturnLinks(slugify(listItem.RefLink)))
 	}
 
 	closeTag := "</li>"
@@ -781,6 +1384,16 @@ func (r *Renderer) listItem(w io.Writer, listItem *ast.ListItem, entering bool)
 }
 
 func (r *Renderer) codeBlock(w io.Writer, codeBlock *ast.CodeBlock) {
+	if fn := r.opts.CodeBlockRenderers[codeBlockLanguage(codeBlock.Info)]; fn != nil {
+		r.cr(w)
+		fn(w, codeBlock)
+		r.lastOutputLen = 1
+		if !isListItem(codeBlock.Parent) {
+			r.cr(w)
+		}
+		return
+	}
+
 	var attrs []string
 	// TODO(miek): this can add multiple class= attribute, they should be coalesced into one.
 	// This is probably true for some other elements as well
@@ -791,10 +1404,10 @@ func (r *Renderer) codeBlock(w io.Writer, codeBlock *ast.CodeBlock) {
 	r.outs(w, "<pre>")
 	code := tagWithAttributes("<code", attrs)
 	r.outs(w, code)
-	if r.opts.Comments != nil {
-		r.EscapeHTMLCallouts(w, codeBlock.Literal)
+	if r.opts.Flags&CodeLineNumbers != 0 {
+		r.codeLines(w, codeBlock.Literal)
 	} else {
-		EscapeHTML(w, codeBlock.Literal)
+		r.codeLiteral(w, codeBlock.Literal)
 	}
 	r.outs(w, "</code>")
 	r.outs(w, "</pre>")
@@ -803,6 +1416,34 @@ func (r *Renderer) codeBlock(w io.Writer, codeBlock *ast.CodeBlock) {
 	}
 }
 
+func (r *Renderer) codeLiteral(w io.Writer, code []byte) {
+	if r.opts.Comments != nil {
+		r.EscapeHTMLCallouts(w, code)
+	} else {
+		EscapeHTML(w, code)
+	}
+}
+
+// codeLines writes each line of code as
+// <span class="line" data-line="N">...</span>, keeping the newlines between
+// them, so line numbers can be shown with CSS.
+func (r *Renderer) codeLines(w io.Writer, code []byte) {
+	for n := 1; len(code) > 0; n++ {
+		line := code
+		rest := []byte(nil)
+		if i := bytes.IndexByte(code, '\n'); i >= 0 {
+			line, rest = code[:i], code[i+1:]
+		}
+		r.outs(w, `<span class="line" data-line="`+strconv.Itoa(n)+`">`)
+		r.codeLiteral(w, line)
+		r.outs(w, "</span>")
+		if rest != nil {
+			r.outs(w, "\n")
+		}
+		code = rest
+	}
+}
+
 func (r *Renderer) caption(w io.Writer, caption *ast.Caption, entering bool) {
 	if entering {
 		r.outs(w, "<figcaption>")
@@ -839,16 +1480,32 @@ func (r *Renderer) tableCell(w io.Writer, tableCell *ast.TableCell, entering boo
 	if align != "" {
 		attrs = append(attrs, fmt.Sprintf(`align="%s"`, align))
 	}
+	if tableCell.ColSpan > 1 {
+		attrs = append(attrs, fmt.Sprintf(`colspan="%d"`, tableCell.ColSpan))
+	}
+	if tableCell.IsHeader && r.opts.Flags&AccessibleTables != 0 {
+		attrs = append(attrs, `scope="col"`)
+	}
 	if ast.GetPrevNode(tableCell) == nil {
 		r.cr(w)
 	}
 	r.outTag(w, openTag, attrs)
 }
 
+// classTag returns the opening tag starting with tag, e.g. "<tbody", with
+// class as class attribute if it's set.
+func classTag(tag, class string) string {
+	var attrs []string
+	if class != "" {
+		attrs = []string{`class="` + class + `"`}
+	}
+	return tagWithAttributes(tag, attrs)
+}
+
 func (r *Renderer) tableBody(w io.Writer, node *ast.TableBody, entering bool) {
 	if entering {
 		r.cr(w)
-		r.outs(w, "<tbody>")
+		r.outs(w, classTag("<tbody", r.opts.TableBodyClass))
 		// XXX: this is to adhere to a rather silly test. Should fix test.
 		if ast.GetFirstChild(node) == nil {
 			r.cr(w)
@@ -863,6 +1520,7 @@ func (r *Renderer) matter(w io.Writer, node *ast.DocumentMatter, entering bool)
 	if !entering {
 		return
 	}
+	r.closeChapter(w)
 	if r.documentMatter != ast.DocumentMatterNone {
 		r.outs(w, "</section>\n")
 	}
@@ -877,6 +1535,20 @@ func (r *Renderer) matter(w io.Writer, node *ast.DocumentMatter, entering bool)
 	r.documentMatter = node.Matter
 }
 
+// alert renders a GitHub alert like > [!NOTE] as a <div> with a title, like
+// GitHub does.
+func (r *Renderer) alert(w io.Writer, quote *ast.BlockQuote, entering bool) {
+	if !entering {
+		r.outOneOfCr(w, false, "", "</div>")
+		return
+	}
+	attrs := appendClass(BlockAttrs(quote), "markdown-alert markdown-alert-"+quote.Alert)
+	r.outOneOfCr(w, true, tagWithAttributes("<div", attrs), "")
+	r.cr(w)
+	r.outs(w, `<p class="markdown-alert-title">`+strings.ToUpper(quote.Alert[:1])+quote.Alert[1:]+"</p>")
+	r.cr(w)
+}
+
 func (r *Renderer) citation(w io.Writer, node *ast.Citation) {
 	for i, c := range node.Destination {
 		attr := []string{`class="none"`}
@@ -920,17 +1592,32 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 	case *ast.Text:
 		r.text(w, node)
 	case *ast.Softbreak:
-		r.cr(w)
-		// TODO: make it configurable via out(renderer.softbreak)
+		r.softBreak(w, node)
 	case *ast.Hardbreak:
 		r.hardBreak(w, node)
 	case *ast.Emph:
-		r.outOneOf(w, entering, "<em>", "</em>")
+		if r.opts.Flags&PresentationalEmphasis != 0 {
+			r.outOneOf(w, entering, "<i>", "</i>")
+		} else {
+			r.outOneOf(w, entering, "<em>", "</em>")
+		}
 	case *ast.Strong:
-		r.outOneOf(w, entering, "<strong>", "</strong>")
+		if r.opts.Flags&PresentationalEmphasis != 0 {
+			r.outOneOf(w, entering, "<b>", "</b>")
+		} else {
+			r.outOneOf(w, entering, "<strong>", "</strong>")
+		}
 	case *ast.Del:
 		r.outOneOf(w, entering, "<del>", "</del>")
+	case *ast.Mark:
+		r.outOneOf(w, entering, "<mark>", "</mark>")
+	case *ast.Ins:
+		r.outOneOf(w, entering, "<ins>", "</ins>")
 	case *ast.BlockQuote:
+		if node.Alert != "" {
+			r.alert(w, node, entering)
+			break
+		}
 		tag := tagWithAttributes("<blockquote", BlockAttrs(node))
 		r.outOneOfCr(w, entering, tag, "</blockquote>")
 	case *ast.Aside:
@@ -959,6 +1646,13 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 	case *ast.Document:
 		// do nothing
 	case *ast.Paragraph:
+		if r.toc != nil && isTOCMarker(node) {
+			if entering {
+				r.cr(w)
+				r.out(w, r.toc)
+			}
+			return ast.SkipChildren
+		}
 		r.paragraph(w, node, entering)
 	case *ast.HTMLSpan:
 		r.htmlSpan(w, node)
@@ -973,12 +1667,20 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 	case *ast.ListItem:
 		r.listItem(w, node, entering)
 	case *ast.Table:
-		tag := tagWithAttributes("<table", BlockAttrs(node))
+		var attrs []string
+		if r.opts.Flags&AccessibleTables != 0 {
+			attrs = append(attrs, `role="table"`)
+		}
+		attrs = append(attrs, BlockAttrs(node)...)
+		if r.opts.TableClass != "" {
+			attrs = appendClass(attrs, r.opts.TableClass)
+		}
+		tag := tagWithAttributes("<table", attrs)
 		r.outOneOfCr(w, entering, tag, "</table>")
 	case *ast.TableCell:
 		r.tableCell(w, node, entering)
 	case *ast.TableHeader:
-		r.outOneOfCr(w, entering, "<thead>", "</thead>")
+		r.outOneOfCr(w, entering, classTag("<thead", r.opts.TableHeadClass), "</thead>")
 	case *ast.TableBody:
 		r.tableBody(w, node, entering)
 	case *ast.TableRow:
@@ -1012,6 +1714,10 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 			Escape(w, node.Literal)
 		}
 		r.outOneOf(w, false, "<sup>", "</sup>")
+	case *ast.Abbreviation:
+		r.abbreviation(w, node)
+	case *ast.Kbd:
+		r.kbd(w, node)
 	case *ast.Footnotes:
 		// nothing by default; just output the list.
 	default:
@@ -1023,13 +1729,45 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 // RenderHeader writes HTML document preamble and TOC if requested.
 func (r *Renderer) RenderHeader(w io.Writer, ast ast.Node) {
 	r.writeDocumentHeader(w)
+	r.toc = nil
+	r.headingLevels, r.headingNumbers = nil, nil
+	r.headingShift = 0
+	r.footnoteRefs = countFootnoteRefs(ast)
+	r.renderedFootnoteRefs = make(map[string]int)
+	r.unbalancedHTML = nil
+	if r.opts.Flags&StrictXHTML != 0 {
+		r.unbalancedHTML = unbalancedHTMLSpans(ast)
+	}
+	r.chapterLevel, r.inChapter = 0, false
+	if r.opts.Flags&ChapterSections != 0 {
+		r.chapterLevel = chapterLevel(ast)
+	}
+	if r.opts.MinHeadingLevel > 0 {
+		if min := minHeadingLevel(ast); min > 0 {
+			r.headingShift = r.opts.MinHeadingLevel - min
+		}
+	}
+	if r.opts.Flags&TOCMarker != 0 && hasTOCMarker(ast) {
+		var buf bytes.Buffer
+		lastOutputLen := r.lastOutputLen
+		r.writeTOC(&buf, ast)
+		r.lastOutputLen = lastOutputLen
+		r.toc = buf.Bytes()
+		return
+	}
 	if r.opts.Flags&TOC != 0 {
 		r.writeTOC(w, ast)
 	}
 }
 
+// PostProcessor returns RendererOptions.PostProcess.
+func (r *Renderer) PostProcessor() func(output []byte) []byte {
+	return r.opts.PostProcess
+}
+
 // RenderFooter writes HTML document footer.
 func (r *Renderer) RenderFooter(w io.Writer, _ ast.Node) {
+	r.closeChapter(w)
 	if r.documentMatter != ast.DocumentMatterNone {
 		r.outs(w, "</section>\n")
 	}
@@ -1045,7 +1783,12 @@ func (r *Renderer) writeDocumentHeader(w io.Writer) {
 		return
 	}
 	ending := ""
-	if r.opts.Flags&UseXHTML != 0 {
+	if r.opts.Flags&EPUB != 0 {
+		io.WriteString(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+		io.WriteString(w, "<!DOCTYPE html>\n")
+		io.WriteString(w, "<html xmlns=\"http://www.w3.org/1999/xhtml\" xmlns:epub=\"http://www.idpf.org/2007/ops\">\n")
+		ending = " /"
+	} else if r.opts.Flags&UseXHTML != 0 {
 		io.WriteString(w, "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" ")
 		io.WriteString(w, "\"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">\n")
 		io.WriteString(w, "<html xmlns=\"http://www.w3.org/1999/xhtml\">\n")
@@ -1057,7 +1800,7 @@ func (r *Renderer) writeDocumentHeader(w io.Writer) {
 	io.WriteString(w, "<head>\n")
 	io.WriteString(w, "  <title>")
 	if r.opts.Flags&Smartypants != 0 {
-		r.sr.Process(w, []byte(r.opts.Title))
+		r.smartypants(w, []byte(r.opts.Title))
 	} else {
 		EscapeHTML(w, []byte(r.opts.Title))
 	}
@@ -1144,6 +1887,32 @@ func (r *Renderer) writeTOC(w io.Writer, doc ast.Node) {
 	r.lastOutputLen = buf.Len()
 }
 
+// isTOCMarker returns true if para only contains [TOC] or [[TOC]].
+func isTOCMarker(para *ast.Paragraph) bool {
+	var text []byte
+	for _, child := range para.GetChildren() {
+		t, ok := child.(*ast.Text)
+		if !ok {
+			return false
+		}
+		text = append(text, t.Literal...)
+	}
+	text = bytes.TrimSpace(text)
+	return string(text) == "[TOC]" || string(text) == "[[TOC]]"
+}
+
+func hasTOCMarker(doc ast.Node) bool {
+	found := false
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if para, ok := node.(*ast.Paragraph); ok && entering && isTOCMarker(para) {
+			found = true
+			return ast.Terminate
+		}
+		return ast.GoToNext
+	})
+	return found
+}
+
 func isList(node ast.Node) bool {
 	_, ok := node.(*ast.List)
 	return ok
@@ -0,0 +1,195 @@
+package html
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// DefaultAllowedHTML is the allow-list used by the SanitizeHTML flag when
+// RendererOptions.AllowedHTML is nil. It maps tag names to the attributes
+// allowed on them. Attributes listed under "*" are allowed on every tag.
+var DefaultAllowedHTML = map[string][]string{
+	"*":          {"id", "class", "title", "lang", "dir"},
+	"a":          {"href", "name"},
+	"abbr":       nil,
+	"b":          nil,
+	"blockquote": {"cite"},
+	"br":         nil,
+	"caption":    nil,
+	"code":       nil,
+	"dd":         nil,
+	"del":        nil,
+	"details":    {"open"},
+	"div":        nil,
+	"dl":         nil,
+	"dt":         nil,
+	"em":         nil,
+	"figcaption": nil,
+	"figure":     nil,
+	"h1":         nil,
+	"h2":         nil,
+	"h3":         nil,
+	"h4":         nil,
+	"h5":         nil,
+	"h6":         nil,
+	"hr":         nil,
+	"i":          nil,
+	"img":        {"src", "alt", "width", "height"},
+	"ins":        nil,
+	"kbd":        nil,
+	"li":         nil,
+	"mark":       nil,
+	"ol":         {"start", "type"},
+	"p":          nil,
+	"pre":        nil,
+	"q":          {"cite"},
+	"s":          nil,
+	"samp":       nil,
+	"small":      nil,
+	"span":       nil,
+	"strong":     nil,
+	"sub":        nil,
+	"summary":    nil,
+	"sup":        nil,
+	"table":      nil,
+	"tbody":      nil,
+	"td":         {"align", "colspan", "rowspan"},
+	"tfoot":      nil,
+	"th":         {"align", "colspan", "rowspan"},
+	"thead":      nil,
+	"tr":         nil,
+	"u":          nil,
+	"ul":         nil,
+}
+
+var (
+	sanitizeTagRe  = regexp.MustCompile(`(?i)^<(/?)(` + tagName + `)(` + attribute + `*)\s*(/?)>`)
+	sanitizeAttrRe = regexp.MustCompile(`\s+(` + attributeName + `)(?:\s*=\s*(` + attributeValue + `))?`)
+)
+
+// elements whose content is dropped together with the tags, mapped to their
+// closing tag
+var sanitizeDropContent = map[string]*regexp.Regexp{
+	"script": regexp.MustCompile(`(?i)</script\s*>`),
+	"style":  regexp.MustCompile(`(?i)</style\s*>`),
+}
+
+// sanitizeHTML returns raw HTML with everything that is not in the allow-list
+// removed. Tags that are not allowed are dropped, but their content is kept,
+// except for <script> and <style> which are dropped entirely. Event handlers
+// (on* attributes) and style attributes are never kept, and neither are URL
+// attributes with a script or data URL.
+func sanitizeHTML(data []byte, allowed map[string][]string) []byte {
+	var out bytes.Buffer
+	i := 0
+	for i < len(data) {
+		j := bytes.IndexByte(data[i:], '<')
+		if j < 0 {
+			out.Write(data[i:])
+			break
+		}
+		out.Write(data[i : i+j])
+		i += j
+
+		if bytes.HasPrefix(data[i:], []byte("<!--")) {
+			end := bytes.Index(data[i+4:], []byte("-->"))
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		m := sanitizeTagRe.FindSubmatchIndex(data[i:])
+		if m == nil {
+			out.WriteString("&lt;")
+			i++
+			continue
+		}
+		tag := data[i : i+m[1]]
+		closing := m[3] > m[2]
+		name := strings.ToLower(string(tag[m[4]:m[5]]))
+		i += m[1]
+
+		if end, ok := sanitizeDropContent[name]; ok {
+			if !closing {
+				if loc := end.FindIndex(data[i:]); loc != nil {
+					i += loc[1]
+				} else {
+					i = len(data)
+				}
+			}
+			continue
+		}
+		attrs, ok := allowed[name]
+		if !ok {
+			continue
+		}
+		out.WriteByte('<')
+		if closing {
+			out.WriteByte('/')
+			out.WriteString(name)
+			out.WriteByte('>')
+			continue
+		}
+		out.WriteString(name)
+		for _, a := range sanitizeAttrRe.FindAllSubmatch(tag[m[6]:m[7]], -1) {
+			attr := strings.ToLower(string(a[1]))
+			if !isAllowedAttr(attr, attrs) && !isAllowedAttr(attr, allowed["*"]) {
+				continue
+			}
+			val := html.UnescapeString(string(unquoteAttr(a[2])))
+			if (attr == "href" || attr == "src" || attr == "cite") && isScriptURL(val) {
+				continue
+			}
+			out.WriteByte(' ')
+			out.WriteString(attr)
+			out.WriteString(`="`)
+			EscapeHTML(&out, []byte(val))
+			out.WriteByte('"')
+		}
+		if m[9] > m[8] {
+			out.WriteString(" /")
+		}
+		out.WriteByte('>')
+	}
+	return out.Bytes()
+}
+
+func isAllowedAttr(attr string, attrs []string) bool {
+	if strings.HasPrefix(attr, "on") || attr == "style" {
+		return false
+	}
+	for _, a := range attrs {
+		if attr == a {
+			return true
+		}
+	}
+	return false
+}
+
+func unquoteAttr(val []byte) []byte {
+	if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+		return val[1 : len(val)-1]
+	}
+	return val
+}
+
+// isScriptURL returns true for URLs that run code when followed. Browsers
+// ignore whitespace and control characters in the scheme, so do we.
+func isScriptURL(url string) bool {
+	url = strings.Map(func(r rune) rune {
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(url))
+	for _, scheme := range []string{"javascript:", "vbscript:", "data:"} {
+		if strings.HasPrefix(url, scheme) {
+			return true
+		}
+	}
+	return false
+}
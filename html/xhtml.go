@@ -0,0 +1,196 @@
+package html
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// elements without content, which are self-closed in XHTML
+var xhtmlVoidElements = map[string]bool{
+	"area":   true,
+	"base":   true,
+	"br":     true,
+	"col":    true,
+	"embed":  true,
+	"hr":     true,
+	"img":    true,
+	"input":  true,
+	"link":   true,
+	"meta":   true,
+	"param":  true,
+	"source": true,
+	"track":  true,
+	"wbr":    true,
+}
+
+// the entities predefined in XML, all the others must be numeric
+var xmlEntityNames = map[string]bool{
+	"amp":  true,
+	"lt":   true,
+	"gt":   true,
+	"quot": true,
+	"apos": true,
+}
+
+var entityRe = regexp.MustCompile(`^&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+
+// xmlEntities returns d with the named entities that are not predefined in
+// XML, e.g. &rsquo;, replaced by numeric character references, and the & that
+// don't start an entity escaped.
+func xmlEntities(d []byte) []byte {
+	if bytes.IndexByte(d, '&') < 0 {
+		return d
+	}
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(d, '&')
+		if i < 0 {
+			out.Write(d)
+			return out.Bytes()
+		}
+		out.Write(d[:i])
+		d = d[i:]
+		entity := entityRe.Find(d)
+		switch {
+		case entity == nil:
+			out.WriteString("&amp;")
+			d = d[1:]
+			continue
+		case entity[1] == '#' || xmlEntityNames[string(entity[1:len(entity)-1])]:
+			out.Write(entity)
+		default:
+			unesc := html.UnescapeString(string(entity))
+			if unesc == string(entity) {
+				// unknown entity
+				out.WriteString("&amp;")
+				out.Write(entity[1:])
+				break
+			}
+			for _, r := range unesc {
+				out.WriteString("&#" + strconv.Itoa(int(r)) + ";")
+			}
+		}
+		d = d[len(entity):]
+	}
+}
+
+// xhtmlTags returns raw HTML with its tags rewritten as XHTML: tag and
+// attribute names are lowercased, attribute values are quoted, attributes
+// without a value get their name as value and void elements like <br> are
+// self-closed. A < that doesn't start a tag is escaped. Comments,
+// processing instructions, declarations and CDATA sections are kept as is.
+func xhtmlTags(data []byte) []byte {
+	var out bytes.Buffer
+	i := 0
+	for i < len(data) {
+		j := bytes.IndexByte(data[i:], '<')
+		if j < 0 {
+			out.Write(xmlEntities(data[i:]))
+			break
+		}
+		out.Write(xmlEntities(data[i : i+j]))
+		i += j
+
+		m := sanitizeTagRe.FindSubmatchIndex(data[i:])
+		if m == nil {
+			if loc := htmlTagRe.FindIndex(data[i:]); loc != nil {
+				out.Write(data[i : i+loc[1]])
+				i += loc[1]
+				continue
+			}
+			out.WriteString("&lt;")
+			i++
+			continue
+		}
+		tag := data[i : i+m[1]]
+		i += m[1]
+		name := strings.ToLower(string(tag[m[4]:m[5]]))
+		out.WriteByte('<')
+		if m[3] > m[2] {
+			out.WriteByte('/')
+			out.WriteString(name)
+			out.WriteByte('>')
+			continue
+		}
+		out.WriteString(name)
+		for _, a := range sanitizeAttrRe.FindAllSubmatch(tag[m[6]:m[7]], -1) {
+			attr := strings.ToLower(string(a[1]))
+			val := html.UnescapeString(string(unquoteAttr(a[2])))
+			if a[2] == nil {
+				val = attr
+			}
+			out.WriteByte(' ')
+			out.WriteString(attr)
+			out.WriteString(`="`)
+			EscapeHTML(&out, []byte(val))
+			out.WriteByte('"')
+		}
+		if m[9] > m[8] || xhtmlVoidElements[name] {
+			out.WriteString(" /")
+		}
+		out.WriteByte('>')
+	}
+	return out.Bytes()
+}
+
+// isWellFormed returns true if d, a fragment of XHTML, is well-formed XML.
+func isWellFormed(d []byte) bool {
+	dec := xml.NewDecoder(io.MultiReader(
+		strings.NewReader("<x>"), bytes.NewReader(d), strings.NewReader("</x>")))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// unbalancedHTMLSpans returns the inline HTML tags of doc that are not closed
+// or opened by a tag with the same parent, rewritten as XHTML. They would
+// make the output malformed, so they are escaped with StrictXHTML.
+func unbalancedHTMLSpans(doc ast.Node) map[*ast.HTMLSpan]bool {
+	unbalanced := map[*ast.HTMLSpan]bool{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering || node.AsContainer() == nil {
+			return ast.GoToNext
+		}
+		type openTag struct {
+			name string
+			span *ast.HTMLSpan
+		}
+		var open []openTag
+		for _, child := range node.GetChildren() {
+			span, ok := child.(*ast.HTMLSpan)
+			if !ok {
+				continue
+			}
+			tag := xhtmlTags(span.Literal)
+			m := sanitizeTagRe.FindSubmatchIndex(tag)
+			switch {
+			case m == nil || m[9] > m[8]:
+				// comment or self-closed tag
+			case m[3] == m[2]:
+				open = append(open, openTag{string(tag[m[4]:m[5]]), span})
+			case len(open) > 0 && open[len(open)-1].name == string(tag[m[4]:m[5]]):
+				open = open[:len(open)-1]
+			default:
+				unbalanced[span] = true
+			}
+		}
+		for _, tag := range open {
+			unbalanced[tag.span] = true
+		}
+		return ast.GoToNext
+	})
+	return unbalanced
+}
@@ -1,7 +1,10 @@
 package markdown
 
 import (
+	"bytes"
+	"encoding/xml"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/gomarkdown/markdown/ast"
@@ -54,3 +57,239 @@ func TestRenderNodeHookCode(t *testing.T) {
 	}
 	doTestsParam(t, tests, params)
 }
+
+func TestCodeBlockRenderers(t *testing.T) {
+	tests := []string{
+		"```mermaid\ngraph TD;\n  A-->B;\n```\n\n```go\nx := 1\n```\n",
+		"<div class=\"mermaid\">graph TD;\n  A--&gt;B;\n</div>\n\n<pre><code class=\"language-go\">x := 1\n</code></pre>\n",
+
+		"```math\ne^{i\\pi} + 1 = 0\n```\n",
+		"$$e^{i\\pi} + 1 = 0\n$$\n",
+	}
+	opts := html.RendererOptions{
+		CodeBlockRenderers: map[string]html.CodeBlockFunc{
+			"mermaid": func(w io.Writer, codeBlock *ast.CodeBlock) {
+				io.WriteString(w, `<div class="mermaid">`)
+				html.EscapeHTML(w, codeBlock.Literal)
+				io.WriteString(w, "</div>")
+			},
+			"math": func(w io.Writer, codeBlock *ast.CodeBlock) {
+				io.WriteString(w, "$$")
+				html.EscapeHTML(w, codeBlock.Literal)
+				io.WriteString(w, "$$")
+			},
+		},
+	}
+	params := TestParams{
+		RendererOptions: opts,
+		extensions:      parser.CommonExtensions,
+	}
+	doTestsParam(t, tests, params)
+}
+
+func TestCodeLineNumbers(t *testing.T) {
+	tests := []string{
+		"```go\nx := 1\n\ny := x < 2\n```\n",
+		"<pre><code class=\"language-go\"><span class=\"line\" data-line=\"1\">x := 1</span>\n" +
+			"<span class=\"line\" data-line=\"2\"></span>\n" +
+			"<span class=\"line\" data-line=\"3\">y := x &lt; 2</span>\n</code></pre>\n",
+
+		"    indented\n",
+		"<pre><code><span class=\"line\" data-line=\"1\">indented</span>\n</code></pre>\n",
+	}
+	params := TestParams{
+		Flags:      html.CodeLineNumbers,
+		extensions: parser.CommonExtensions,
+	}
+	doTestsParam(t, tests, params)
+}
+
+func renderHookSoftbreak(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	if _, ok := node.(*ast.Softbreak); !ok {
+		return ast.GoToNext, false
+	}
+	io.WriteString(w, " ")
+	return ast.GoToNext, true
+}
+
+func TestSoftbreak(t *testing.T) {
+	tests := []string{
+		"one\ntwo\n",
+		"<p>one\ntwo</p>\n",
+
+		"* one\n  two\n",
+		"<ul>\n<li>one\ntwo</li>\n</ul>\n",
+	}
+	doTestsParam(t, tests, TestParams{})
+
+	tests = []string{
+		"one\ntwo\n",
+		"<p>one two</p>\n",
+
+		"*one\ntwo*\n",
+		"<p><em>one two</em></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{
+			RenderNodeHook: renderHookSoftbreak,
+		},
+	})
+}
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []string{
+		"<div onclick=\"alert(1)\" class=\"note\">\nhello\n</div>\n",
+		"<div class=\"note\">\nhello\n</div>\n",
+
+		"<div>\n<script>alert(1)</script>\n<style>p { color: red }</style>\n<p style=\"x\">text</p>\n</div>\n",
+		"<div>\n\n\n<p>text</p>\n</div>\n",
+
+		"<div>\n<a href=\"javascript:alert(1)\" title=\"t\">a</a>\n<a HREF='/ok'>b</a>\n</div>\n",
+		"<div>\n<a title=\"t\">a</a>\n<a href=\"/ok\">b</a>\n</div>\n",
+
+		"<div>\n<iframe src=\"http://evil\"></iframe><!-- gone -->\n<img src=\"/a.png\" onerror=\"x()\" />\n</div>\n",
+		"<div>\n\n<img src=\"/a.png\" />\n</div>\n",
+
+		"text <span onmouseover=\"x()\">span</span> <b>bold</b>\n",
+		"<p>text <span>span</span> <b>bold</b></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		Flags: html.SanitizeHTML,
+	})
+
+	tests = []string{
+		"<div onclick=\"alert(1)\" data-x=\"1\">\nhello\n</div>\n",
+		"\nhello\n\n",
+
+		"<section data-x=\"1\" onclick=\"alert(1)\">\nhello\n</section>\n",
+		"<section data-x=\"1\">\nhello\n</section>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		Flags: html.SanitizeHTML,
+		RendererOptions: html.RendererOptions{
+			AllowedHTML: map[string][]string{
+				"section": {"data-x", "onclick"},
+			},
+		},
+	})
+}
+
+func TestTextEntities(t *testing.T) {
+	tests := []string{
+		"it's \"5 < 6\" & [Tom's](/t) `'`\n",
+		"<p>it's &quot;5 &lt; 6&quot; &amp; <a href=\"/t\">Tom's</a> <code>'</code></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{})
+
+	tests = []string{
+		"it's \"5 < 6\" & [Tom's](/t) `'`\n",
+		"<p>it&#39;s &quot;5 &lt; 6&quot; &amp; <a href=\"/t\">Tom&#39;s</a> <code>'</code></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{
+			TextEntities: map[byte]string{'\'': "&#39;"},
+		},
+	})
+}
+
+func TestStrictXHTML(t *testing.T) {
+	tests := []string{
+		"a<BR>b <img SRC=/x.png alt=x> <input type=checkbox checked>\n",
+		"<p>a<br />b <img src=\"/x.png\" alt=\"x\" /> <input type=\"checkbox\" checked=\"checked\" /></p>\n",
+
+		"it's R&D -- <b>bold</b> <i>unclosed\n",
+		"<p>it&#8217;s R&amp;D &#8212; <b>bold</b> &lt;i&gt;unclosed</p>\n",
+
+		"<div CLASS=x>\n<HR>\n</div>\n",
+		"<div class=\"x\">\n<hr />\n</div>\n",
+
+		"<div class=x>\n<p>open\n</div>\n",
+		"&lt;div class=&quot;x&quot;&gt;\n&lt;p&gt;open\n&lt;/div&gt;\n",
+	}
+	doTestsParam(t, tests, TestParams{
+		Flags: html.StrictXHTML | html.Smartypants | html.SmartypantsDashes,
+	})
+
+	doc := `# Title
+
+Some *text*, **bold** and it's <SPAN CLASS=x>raw</SPAN> -- 1/2 &nbsp; &hellip;
+with a line break  
+and <br> and an <img src=a.png>.
+
+<DIV ID=d>
+<HR>
+</DIV>
+
+* [link](/a?b=1&c=2 "it's")
+* ![image](/i.png "title")
+
+1. one
+2. two
+
+| a | b |
+|---|---|
+| 1 | 2 |
+
+    code <not> & tag
+
+***
+
+Note[^1] and term
+: definition
+
+[^1]: A footnote.
+`
+	renderer := html.NewRenderer(html.RendererOptions{
+		Flags: html.StrictXHTML | html.CommonFlags | html.FootnoteReturnLinks | html.CompletePage,
+		Title: "It's a test",
+	})
+	p := parser.NewWithExtensions(parser.CommonExtensions | parser.Footnotes)
+	out := ToHTML([]byte(doc), p, renderer)
+	// strip the doctype, it references a DTD the decoder doesn't load
+	out = out[bytes.Index(out, []byte("<html")):]
+	dec := xml.NewDecoder(bytes.NewReader(out))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("output is not well-formed XML: %s\n%s", err, out)
+		}
+	}
+	if strings.Contains(string(out), "&rsquo;") {
+		t.Errorf("named entity in output:\n%s", out)
+	}
+}
+
+func TestChapterSections(t *testing.T) {
+	tests := []string{
+		"intro\n\n## One\n\ntext\n\n### Sub\n\n## Two\n\n> # quoted\n",
+		"<p>intro</p>\n\n<section>\n\n<h2>One</h2>\n\n<p>text</p>\n\n<h3>Sub</h3>\n\n</section>\n\n" +
+			"<section>\n\n<h2>Two</h2>\n\n<blockquote>\n<h1>quoted</h1>\n</blockquote>\n\n</section>\n",
+
+		"no headings\n",
+		"<p>no headings</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.ChapterSections})
+}
+
+func TestCollapseWhitespace(t *testing.T) {
+	tests := []string{
+		"one   two\nthree\t\tfour *a  b*\n",
+		"<p>one   two\nthree\t\tfour <em>a  b</em></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{})
+
+	tests = []string{
+		"one   two\nthree\t\tfour *a  b*\n",
+		"<p>one two three four <em>a b</em></p>\n",
+
+		"    code   stays\n",
+		"<pre><code>code   stays\n</code></pre>\n",
+
+		"`code   span`\n",
+		"<p><code>code   span</code></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.CollapseWhitespace})
+}
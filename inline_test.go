@@ -1,11 +1,16 @@
 package markdown
 
 import (
+	"bytes"
+	"fmt"
+	"io"
 	"regexp"
 	"testing"
+	"time"
 
 	"strings"
 
+	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
 )
@@ -73,6 +78,232 @@ func TestReferenceOverride(t *testing.T) {
 	})
 }
 
+func TestMissingReference(t *testing.T) {
+	var tests = []string{
+		"see [Home Page][home]\n",
+		"<p>see <a href=\"/wiki/home\" title=\"Home\">Home Page</a></p>\n",
+
+		"see [home][]\n",
+		"<p>see <a href=\"/wiki/home\" title=\"Home\">home</a></p>\n",
+
+		"see [home]\n",
+		"<p>see <a href=\"/wiki/home\" title=\"Home\">home</a></p>\n",
+
+		"see [![logo](/logo.png)][home]\n",
+		"<p>see <a href=\"/wiki/home\" title=\"Home\"><img src=\"/logo.png\" alt=\"logo\" /></a></p>\n",
+
+		// defined in the document, the callback is not consulted
+		"see [home]\n\n[home]: /defined\n",
+		"<p>see <a href=\"/defined\">home</a></p>\n",
+
+		// not resolved, printed verbatim
+		"see [Other][other]\n",
+		"<p>see [Other][other]</p>\n",
+
+		"see [other]\n",
+		"<p>see [other]</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		missingRef: func(id []byte) (url, title []byte, ok bool) {
+			if string(id) == "home" {
+				return []byte("/wiki/home"), []byte("Home"), true
+			}
+			return nil, nil, false
+		},
+	})
+}
+
+func TestOptionsReferences(t *testing.T) {
+	var tests = []string{
+		"see [Go][go]\n",
+		"<p>see <a href=\"https://go.dev/\" title=\"The Go language\">Go</a></p>\n",
+
+		"see [GO]\n",
+		"<p>see <a href=\"https://go.dev/\" title=\"The Go language\">GO</a></p>\n",
+
+		// defined in the document, which wins
+		"see [go]\n\n[go]: https://golang.org/\n",
+		"<p>see <a href=\"https://golang.org/\">go</a></p>\n",
+
+		"see [other]\n",
+		"<p>see [other]</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		parserOptions: parser.Options{
+			References: map[string]parser.Reference{
+				"Go": {Link: "https://go.dev/", Title: "The Go language"},
+			},
+		},
+	})
+}
+
+func TestWikiLinks(t *testing.T) {
+	var tests = []string{
+		"see [[Main Page]]\n",
+		"<p>see <a href=\"main-page\">Main Page</a></p>\n",
+
+		"see [[Main Page|the *main* page]] now\n",
+		"<p>see <a href=\"main-page\">the <em>main</em> page</a> now</p>\n",
+
+		"[[ Spaced | Label ]]\n",
+		"<p><a href=\"spaced\">Label</a></p>\n",
+
+		"not [[closed\n",
+		"<p>not [[closed</p>\n",
+
+		"empty [[]] and [[|label]]\n",
+		"<p>empty [[]] and [[|label]]</p>\n",
+
+		"[[link](/url)]\n",
+		"<p>[<a href=\"/url\">link</a>]</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.WikiLinks})
+
+	tests = []string{
+		"see [[Main Page]]\n",
+		"<p>see <a href=\"/wiki/Main_Page\">Main Page</a></p>\n",
+
+		"see [[Main Page|home]]\n",
+		"<p>see <a href=\"/wiki/Main_Page\">home</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.WikiLinks,
+		parserOptions: parser.Options{
+			WikiLinkFn: func(page []byte) []byte {
+				return append([]byte("/wiki/"), bytes.ReplaceAll(page, []byte(" "), []byte("_"))...)
+			},
+		},
+	})
+
+	tests = []string{
+		"see [[Main Page]]\n",
+		"<p>see [[Main Page]]</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{})
+}
+
+func TestKeyboard(t *testing.T) {
+	var tests = []string{
+		"press [[[Ctrl]]]+[[[C]]] to copy\n",
+		"<p>press <kbd>Ctrl</kbd>+<kbd>C</kbd> to copy</p>\n",
+
+		"[[[ Shift ]]] and [[[<Esc>]]]\n",
+		"<p><kbd>Shift</kbd> and <kbd>&lt;Esc&gt;</kbd></p>\n",
+
+		"not [[[closed and [[[]]] [[[ ]]] [[[a]]b]]]\n",
+		"<p>not [[[closed and [[[]]] [[[ ]]] [[[a]]b]]]</p>\n",
+
+		"[[[link](/url)]]\n",
+		"<p>[[<a href=\"/url\">link</a>]]</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.Keyboard})
+
+	// keys and wiki links can be used together
+	tests = []string{
+		"[[[Ctrl]]] opens [[Settings]]\n",
+		"<p><kbd>Ctrl</kbd> opens <a href=\"settings\">Settings</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.Keyboard | parser.WikiLinks})
+
+	// keys rendered by a callback
+	tests = []string{
+		"[[[Up]]]\n",
+		"<p><kbd class=\"key-up\">&uarr;</kbd></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.Keyboard,
+		RendererOptions: html.RendererOptions{
+			KbdRenderer: func(w io.Writer, key []byte) {
+				io.WriteString(w, `<kbd class="key-`+strings.ToLower(string(key))+`">&uarr;</kbd>`)
+			},
+		},
+	})
+}
+
+func TestMentions(t *testing.T) {
+	var tests = []string{
+		"@alice said hi\n",
+		"<p><a href=\"/users/alice\">@alice</a> said hi</p>\n",
+
+		"thanks @bob-smith, and #go_lang!\n",
+		"<p>thanks <a href=\"/users/bob-smith\">@bob-smith</a>, and <a href=\"/tags/go_lang\">#go_lang</a>!</p>\n",
+
+		"#release notes\n",
+		"<p><a href=\"/tags/release\">#release</a> notes</p>\n",
+
+		// not mentions or hashtags
+		"mail alice@example.com about issue#12 or #42 or @ alone\n",
+		"<p>mail alice@example.com about issue#12 or #42 or @ alone</p>\n",
+
+		"`@code` and [@link](/x) and <http://example.com/#frag>\n",
+		"<p><code>@code</code> and <a href=\"/x\">@link</a> and <a href=\"http://example.com/#frag\">http://example.com/#frag</a></p>\n",
+
+		"# Heading with #tag {#id}\n",
+		"<h1 id=\"id\">Heading with <a href=\"/tags/tag\">#tag</a></h1>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.Mentions | parser.HeadingIDs | parser.SpaceHeadings,
+		parserOptions: parser.Options{
+			MentionFn: func(name []byte) []byte {
+				return append([]byte("/users/"), name...)
+			},
+			HashtagFn: func(tag []byte) []byte {
+				return append([]byte("/tags/"), tag...)
+			},
+		},
+	})
+
+	// only the configured kind is linked
+	tests = []string{
+		"@alice likes #go\n",
+		"<p><a href=\"/users/alice\">@alice</a> likes #go</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.Mentions,
+		parserOptions: parser.Options{
+			MentionFn: func(name []byte) []byte {
+				return append([]byte("/users/"), name...)
+			},
+		},
+	})
+}
+
+func TestEmoji(t *testing.T) {
+	var tests = []string{
+		"ship it :rocket: :+1:\n",
+		"<p>ship it 🚀 👍</p>\n",
+
+		":tada::tada:\n",
+		"<p>🎉🎉</p>\n",
+
+		// unknown shortcodes and colons that are not emoji
+		"this :notanemoji: here\n",
+		"<p>this :notanemoji: here</p>\n",
+
+		"at 10:30:45, key: value, see http://example.com/ :\n",
+		"<p>at 10:30:45, key: value, see <a href=\"http://example.com/\">http://example.com/</a> :</p>\n",
+
+		"`:smile:` in code\n",
+		"<p><code>:smile:</code> in code</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.Emoji})
+
+	tests = []string{
+		":party: and :smile:\n",
+		"<p><img src=\"/emoji/party.png\" alt=\":party:\" /> and 😀</p>\n",
+
+		":rocket:\n",
+		"<p>:rocket:</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.Emoji,
+		parserOptions: parser.Options{
+			Emoji:       map[string]string{"smile": "😀"},
+			CustomEmoji: map[string]string{"party": "/emoji/party.png"},
+		},
+	})
+}
+
 func TestStrong(t *testing.T) {
 	var tests = []string{
 		"nothing inline\n",
@@ -140,6 +371,194 @@ func TestStrongShort(t *testing.T) {
 	doTestsInline(t, tests)
 
 }
+func TestEmphasisUnicodePunctuation(t *testing.T) {
+	var tests = []string{
+		"*foo*—bar\n",
+		"<p><em>foo</em>—bar</p>\n",
+
+		"**foo**。\n",
+		"<p><strong>foo</strong>。</p>\n",
+
+		"「*强调*」\n",
+		"<p>「<em>强调</em>」</p>\n",
+
+		"*foo*bar\n",
+		"<p>*foo*bar</p>\n",
+
+		"*\u00a0foo*\n",
+		"<p>*\u00a0foo*</p>\n",
+
+		"*foo\u3000*\n",
+		"<p>*foo\u3000*</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.NoIntraEmphasis,
+	})
+}
+
+func TestEmphasisMultiByte(t *testing.T) {
+	var tests = []string{
+		"*😀 emoji 😀*\n",
+		"<p><em>😀 emoji 😀</em></p>\n",
+
+		"**漢字**と*かな*\n",
+		"<p><strong>漢字</strong>と<em>かな</em></p>\n",
+
+		"_über_ and ~~ärger~~\n",
+		"<p><em>über</em> and <del>ärger</del></p>\n",
+
+		"`  日本語  ` code\n",
+		"<p><code> 日本語 </code> code</p>\n",
+
+		"*a `*` 😀*\n",
+		"<p><em>a <code>*</code> 😀</em></p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestEmphasisAtEndOfInput(t *testing.T) {
+	var tests = []string{
+		"a **",
+		"<p>a **</p>\n",
+
+		"*",
+		"<p>*</p>\n",
+
+		"~~",
+		"<p>~~</p>\n",
+
+		"~",
+		"<p>~</p>\n",
+
+		"a _",
+		"<p>a _</p>\n",
+
+		"a ***",
+		"<p>a ***</p>\n",
+
+		"***a",
+		"<p>***a</p>\n",
+
+		"a__",
+		"<p>a__</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.SuperSubscript})
+}
+
+func TestNoIntraUnderscore(t *testing.T) {
+	var tests = []string{
+		"foo_bar_baz\n",
+		"<p>foo_bar_baz</p>\n",
+
+		"foo*bar*baz\n",
+		"<p>foo<em>bar</em>baz</p>\n",
+
+		"foo__bar__baz\n",
+		"<p>foo__bar__baz</p>\n",
+
+		"foo**bar**baz\n",
+		"<p>foo<strong>bar</strong>baz</p>\n",
+
+		"snake_case_words and _emph_\n",
+		"<p>snake_case_words and <em>emph</em></p>\n",
+
+		"_foo_bar baz_\n",
+		"<p><em>foo_bar baz</em></p>\n",
+
+		"__foo__bar baz__\n",
+		"<p><strong>foo__bar baz</strong></p>\n",
+
+		"___foo___bar baz___\n",
+		"<p><strong><em>foo___bar baz</em></strong></p>\n",
+
+		"_foo_\n",
+		"<p><em>foo</em></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.NoIntraUnderscore})
+}
+
+func TestNoIntraStrikethrough(t *testing.T) {
+	var tests = []string{
+		"a~~b~~c\n",
+		"<p>a~~b~~c</p>\n",
+
+		"a ~~b~~ c\n",
+		"<p>a <del>b</del> c</p>\n",
+
+		"~~foo~~bar baz~~\n",
+		"<p><del>foo~~bar baz</del></p>\n",
+
+		"foo~~~bar\n",
+		"<p>foo~~~bar</p>\n",
+
+		"~~foo~~.\n",
+		"<p><del>foo</del>.</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.NoIntraStrikethrough})
+
+	// subscripts are unaffected
+	tests = []string{
+		"H~2~O a~~b~~c\n",
+		"<p>H<sub>2</sub>O a~~b~~c</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.NoIntraStrikethrough | parser.SuperSubscript})
+
+	// without the extension
+	tests = []string{
+		"a~~b~~c\n",
+		"<p>a<del>b</del>c</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{})
+}
+
+func TestStrictCommonMarkEmphasis(t *testing.T) {
+	// examples from the CommonMark spec
+	var tests = []string{
+		"*foo bar*\n",
+		"<p><em>foo bar</em></p>\n",
+
+		"a * foo bar*\n",
+		"<p>a * foo bar*</p>\n",
+
+		"foo*bar*\n",
+		"<p>foo<em>bar</em></p>\n",
+
+		"_foo_bar\n",
+		"<p>_foo_bar</p>\n",
+
+		"foo_bar_baz\n",
+		"<p>foo_bar_baz</p>\n",
+
+		"*(*foo*)*\n",
+		"<p><em>(<em>foo</em>)</em></p>\n",
+
+		"**foo*\n",
+		"<p>*<em>foo</em></p>\n",
+
+		"*foo**bar**baz*\n",
+		"<p><em>foo<strong>bar</strong>baz</em></p>\n",
+
+		"foo***bar***baz\n",
+		"<p>foo<em><strong>bar</strong></em>baz</p>\n",
+
+		"*foo**bar*\n",
+		"<p><em>foo**bar</em></p>\n",
+
+		"**a*b**c*\n",
+		"<p><strong>a*b</strong>c*</p>\n",
+
+		"*foo [bar*](/url)\n",
+		"<p>*foo <a href=\"/url\">bar*</a></p>\n",
+
+		"*a `*`*\n",
+		"<p><em>a <code>*</code></em></p>\n",
+
+		"~~del~~ and **strong**\n",
+		"<p><del>del</del> and <strong>strong</strong></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.StrictCommonMark})
+}
+
 func TestEmphasisMix(t *testing.T) {
 	var tests = []string{
 		"***triple emphasis***\n",
@@ -169,6 +588,78 @@ func TestEmphasisMix(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestAdjacentEmphasis(t *testing.T) {
+	var tests = []string{
+		"**a** **b**\n",
+		"<p><strong>a</strong> <strong>b</strong></p>\n",
+
+		"__a__ __b__\n",
+		"<p><strong>a</strong> <strong>b</strong></p>\n",
+
+		"*a* *b*\n",
+		"<p><em>a</em> <em>b</em></p>\n",
+
+		"**foo bar** **baz qux**\n",
+		"<p><strong>foo bar</strong> <strong>baz qux</strong></p>\n",
+
+		"**a**, **b** and **c**\n",
+		"<p><strong>a</strong>, <strong>b</strong> and <strong>c</strong></p>\n",
+
+		"***a*** ***b***\n",
+		"<p><strong><em>a</em></strong> <strong><em>b</em></strong></p>\n",
+
+		"**a**\n**b**\n",
+		"<p><strong>a</strong>\n<strong>b</strong></p>\n",
+
+		"**a ** **b**\n",
+		"<p>**a ** <strong>b</strong></p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestEmphasisNestedDifferentChars(t *testing.T) {
+	var tests = []string{
+		"*a _b_ c*\n",
+		"<p><em>a <em>b</em> c</em></p>\n",
+
+		"_a *b* c_\n",
+		"<p><em>a <em>b</em> c</em></p>\n",
+
+		"*a __b__ c*\n",
+		"<p><em>a <strong>b</strong> c</em></p>\n",
+
+		"__a *b* c__\n",
+		"<p><strong>a <em>b</em> c</strong></p>\n",
+
+		"**a _b_ c**\n",
+		"<p><strong>a <em>b</em> c</strong></p>\n",
+
+		"_a **b** c_\n",
+		"<p><em>a <strong>b</strong> c</em></p>\n",
+
+		// the inner emphasis isn't closed
+		"*a _b c* d_\n",
+		"<p><em>a _b c</em> d_</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestPresentationalEmphasis(t *testing.T) {
+	var tests = []string{
+		"*a* **b** ***c***\n",
+		"<p><i>a</i> <b>b</b> <b><i>c</i></b></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		Flags: html.PresentationalEmphasis,
+	})
+
+	tests = []string{
+		"*a* **b**\n",
+		"<p><em>a</em> <strong>b</strong></p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
 func TestEmphasisLink(t *testing.T) {
 	var tests = []string{
 		"[first](before) *text[second] (inside)text* [third](after)\n",
@@ -186,6 +677,35 @@ func TestEmphasisLink(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestEmphasisCodeSpan(t *testing.T) {
+	var tests = []string{
+		"*a `b` c*\n",
+		"<p><em>a <code>b</code> c</em></p>\n",
+
+		"*a `b*` c*\n",
+		"<p><em>a <code>b*</code> c</em></p>\n",
+
+		"*a ``b*`` c*\n",
+		"<p><em>a <code>b*</code> c</em></p>\n",
+
+		"_a ``b_`` c_\n",
+		"<p><em>a <code>b_</code> c</em></p>\n",
+
+		"**a ``x*y`` c**\n",
+		"<p><strong>a <code>x*y</code> c</strong></p>\n",
+
+		"*a `` b` `` c*\n",
+		"<p><em>a <code>b`</code> c</em></p>\n",
+
+		"*a ``` b`` ``` c*\n",
+		"<p><em>a <code>b``</code> c</em></p>\n",
+
+		"*a `b* c*\n",
+		"<p><em>a `b</em> c*</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
 func TestStrikeThrough(t *testing.T) {
 	var tests = []string{
 		"nothing inline\n",
@@ -215,6 +735,99 @@ func TestStrikeThrough(t *testing.T) {
 	doTestsInline(t, tests)
 }
 
+func TestHighlight(t *testing.T) {
+	var tests = []string{
+		"simple ==inline== test\n",
+		"<p>simple <mark>inline</mark> test</p>\n",
+
+		"==try two== in ==one *line*==\n",
+		"<p><mark>try two</mark> in <mark>one <em>line</em></mark></p>\n",
+
+		"a = b\n",
+		"<p>a = b</p>\n",
+
+		"a =b=\n",
+		"<p>a =b=</p>\n",
+
+		"a == b\n",
+		"<p>a == b</p>\n",
+
+		"==unmatched\n",
+		"<p>==unmatched</p>\n",
+
+		"odd ==number of== markers== here\n",
+		"<p>odd <mark>number of</mark> markers== here</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.Highlight})
+
+	// without the extension
+	tests = []string{
+		"simple ==inline== test\n",
+		"<p>simple ==inline== test</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestInsert(t *testing.T) {
+	var tests = []string{
+		"simple ++inline++ test\n",
+		"<p>simple <ins>inline</ins> test</p>\n",
+
+		"++x++\n",
+		"<p><ins>x</ins></p>\n",
+
+		"C++ is not C\n",
+		"<p>C++ is not C</p>\n",
+
+		"C++ and C++\n",
+		"<p>C++ and C++</p>\n",
+
+		"i++ + j\n",
+		"<p>i++ + j</p>\n",
+
+		"++unmatched\n",
+		"<p>++unmatched</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.Insert})
+
+	// without the extension
+	tests = []string{
+		"simple ++inline++ test\n",
+		"<p>simple ++inline++ test</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestSubscript(t *testing.T) {
+	var tests = []string{
+		"H~2~O\n",
+		"<p>H<sub>2</sub>O</p>\n",
+
+		"a~b~c\n",
+		"<p>a<sub>b</sub>c</p>\n",
+
+		"~~gone~~\n",
+		"<p><del>gone</del></p>\n",
+
+		"~~gone~~ and H~2~O\n",
+		"<p><del>gone</del> and H<sub>2</sub>O</p>\n",
+
+		"a ~b c~\n",
+		"<p>a ~b c~</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.SuperSubscript})
+
+	// subscripts without strikethrough
+	tests = []string{
+		"H~2~O\n",
+		"<p>H<sub>2</sub>O</p>\n",
+
+		"~~kept~~\n",
+		"<p>~~kept~~</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{extensions: parser.SuperSubscript})
+}
+
 func TestCodeSpan(t *testing.T) {
 	var tests = []string{
 		"`source code`\n",
@@ -233,63 +846,223 @@ func TestCodeSpan(t *testing.T) {
 		"<p>a single multi-tick marker with ``` no text</p>\n",
 
 		"markers with ` ` a space\n",
-		"<p>markers with  a space</p>\n",
+		"<p>markers with <code> </code> a space</p>\n",
+
+		"`source code` and a `stray\n",
+		"<p><code>source code</code> and a `stray</p>\n",
+
+		"`source *with* _awkward characters_ in it`\n",
+		"<p><code>source *with* _awkward characters_ in it</code></p>\n",
+
+		"`split over\ntwo lines`\n",
+		"<p><code>split over\ntwo lines</code></p>\n",
+
+		"```multiple ticks``` for the marker\n",
+		"<p><code>multiple ticks</code> for the marker</p>\n",
+
+		"```multiple ticks `with` ticks inside```\n",
+		"<p><code>multiple ticks `with` ticks inside</code></p>\n",
+
+		"\\`not code\\`\n",
+		"<p>`not code`</p>\n",
+
+		"\\*not emphasis\\* and `code`\n",
+		"<p>*not emphasis* and <code>code</code></p>\n",
+
+		"` a `\n",
+		"<p><code>a</code></p>\n",
+
+		"`a `\n",
+		"<p><code>a </code></p>\n",
+
+		"`  a  `\n",
+		"<p><code> a </code></p>\n",
+
+		"`` ` ``\n",
+		"<p><code>`</code></p>\n",
+
+		"`   `\n",
+		"<p><code>   </code></p>\n",
+	}
+	doTestsInline(t, tests)
+
+	tests = []string{
+		"` a `\n",
+		"<p><code> a </code></p>\n",
+
+		"`a `\n",
+		"<p><code>a </code></p>\n",
+
+		"`  a  `\n",
+		"<p><code>  a  </code></p>\n",
+	}
+	doTestsParam(t, tests, TestParams{parserOptions: parser.Options{Flags: parser.KeepCodeSpanSpaces}})
+}
+
+func TestLineBreak(t *testing.T) {
+	var tests = []string{
+		"this line  \nhas a break\n",
+		"<p>this line<br />\nhas a break</p>\n",
+
+		"this line \ndoes not\n",
+		"<p>this line\ndoes not</p>\n",
+
+		"this line\\\ndoes not\n",
+		"<p>this line\\\ndoes not</p>\n",
+
+		"this line\\ \ndoes not\n",
+		"<p>this line\\\ndoes not</p>\n",
+
+		"this has an   \nextra space\n",
+		"<p>this has an<br />\nextra space</p>\n",
+	}
+	doTestsInline(t, tests)
+
+	tests = []string{
+		"this line  \nhas a break\n",
+		"<p>this line<br />\nhas a break</p>\n",
+
+		"this line \ndoes not\n",
+		"<p>this line\ndoes not</p>\n",
+
+		"this line\\\nhas a break\n",
+		"<p>this line<br />\nhas a break</p>\n",
+
+		"this line\\ \ndoes not\n",
+		"<p>this line\\\ndoes not</p>\n",
+
+		"this has an   \nextra space\n",
+		"<p>this has an<br />\nextra space</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.BackslashLineBreak})
+
+	tests = []string{
+		"a\\\nb\n",
+		"<p>a<br />\nb</p>\n",
+
+		// an escaped backslash doesn't break the line
+		"a\\\\\nb\n",
+		"<p>a\\\nb</p>\n",
+
+		"`a\\`\nb\n",
+		"<p><code>a\\</code>\nb</p>\n",
+
+		"* a\\\n  b\n",
+		"<ul>\n<li>a<br />\nb</li>\n</ul>\n",
+
+		"> a\\\n> b\n",
+		"<blockquote>\n<p>a<br />\nb</p>\n</blockquote>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.BackslashLineBreak})
 
-		"`source code` and a `stray\n",
-		"<p><code>source code</code> and a `stray</p>\n",
+	// without the extension the backslash is kept
+	tests = []string{
+		"a\\\nb\n",
+		"<p>a\\\nb</p>\n",
+	}
+	doTestsInline(t, tests)
 
-		"`source *with* _awkward characters_ in it`\n",
-		"<p><code>source *with* _awkward characters_ in it</code></p>\n",
+	// trailing spaces are only trimmed from the current line, never from
+	// the spans before them
+	tests = []string{
+		"*foo bar*  \nbaz\n",
+		"<p><em>foo bar</em><br />\nbaz</p>\n",
 
-		"`split over\ntwo lines`\n",
-		"<p><code>split over\ntwo lines</code></p>\n",
+		"[a ](/u)  \nb\n",
+		"<p><a href=\"/u\">a </a><br />\nb</p>\n",
 
-		"```multiple ticks``` for the marker\n",
-		"<p><code>multiple ticks</code> for the marker</p>\n",
+		"**x** \nb\n",
+		"<p><strong>x</strong>\nb</p>\n",
 
-		"```multiple ticks `with` ticks inside```\n",
-		"<p><code>multiple ticks `with` ticks inside</code></p>\n",
+		"a *b *c*   \nd\n",
+		"<p>a *b <em>c</em><br />\nd</p>\n",
 	}
 	doTestsInline(t, tests)
 }
 
-func TestLineBreak(t *testing.T) {
+func TestNoTrailingSpaceBreak(t *testing.T) {
 	var tests = []string{
-		"this line  \nhas a break\n",
-		"<p>this line<br />\nhas a break</p>\n",
-
-		"this line \ndoes not\n",
-		"<p>this line\ndoes not</p>\n",
-
-		"this line\\\ndoes not\n",
-		"<p>this line\\\ndoes not</p>\n",
-
-		"this line\\ \ndoes not\n",
-		"<p>this line\\\ndoes not</p>\n",
+		"this line  \nhas no break\n",
+		"<p>this line\nhas no break</p>\n",
 
 		"this has an   \nextra space\n",
-		"<p>this has an<br />\nextra space</p>\n",
+		"<p>this has an\nextra space</p>\n",
+
+		"this line\\\nhas a break\n",
+		"<p>this line<br />\nhas a break</p>\n",
 	}
-	doTestsInline(t, tests)
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.NoTrailingSpaceBreak | parser.BackslashLineBreak})
 
+	// without the extension
 	tests = []string{
 		"this line  \nhas a break\n",
 		"<p>this line<br />\nhas a break</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.BackslashLineBreak})
+}
 
-		"this line \ndoes not\n",
-		"<p>this line\ndoes not</p>\n",
+func TestNewlineBreaks(t *testing.T) {
+	var tests = []string{
+		"first line\nsecond line\n",
+		"<p>first line<br />\nsecond line</p>\n",
 
-		"this line\\\nhas a break\n",
+		"a hard break  \nstays one\n",
+		"<p>a hard break<br />\nstays one</p>\n",
+
+		"* item\n  continued\n",
+		"<ul>\n<li>item<br />\ncontinued</li>\n</ul>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{Flags: html.NewlineBreaks})
+
+	tests = []string{
+		"first line\nsecond line\n",
+		"<p>first line<br>\nsecond line</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.NewlineBreaks})
+}
+
+func TestHardLineBreak(t *testing.T) {
+	var tests = []string{
+		"this line\nhas a break\n",
 		"<p>this line<br />\nhas a break</p>\n",
 
-		"this line\\ \ndoes not\n",
-		"<p>this line\\\ndoes not</p>\n",
+		"* item with\n  a break\n* second\n",
+		"<ul>\n<li>item with<br />\na break</li>\n<li>second</li>\n</ul>\n",
 
-		"this has an   \nextra space\n",
-		"<p>this has an<br />\nextra space</p>\n",
+		"> quoted line\n> with a break\n",
+		"<blockquote>\n<p>quoted line<br />\nwith a break</p>\n</blockquote>\n",
 	}
 	doTestsInlineParam(t, tests, TestParams{
-		extensions: parser.BackslashLineBreak})
+		extensions: parser.HardLineBreak})
+}
+
+func TestParagraphWhitespace(t *testing.T) {
+	var tests = []string{
+		"![img](/a.png)\n",
+		"<p><img src=\"/a.png\" alt=\"img\" /></p>\n",
+
+		"   ![img](/a.png)   \n",
+		"<p><img src=\"/a.png\" alt=\"img\" /></p>\n",
+
+		"* ![img](/a.png)  \n",
+		"<ul>\n<li><img src=\"/a.png\" alt=\"img\" /></li>\n</ul>\n",
+
+		"\\ foo\n",
+		"<p>\\ foo</p>\n",
+
+		"foo\\\n",
+		"<p>foo\\</p>\n",
+
+		"foo\\ \n",
+		"<p>foo\\</p>\n",
+
+		"> foo  \n",
+		"<blockquote>\n<p>foo</p>\n</blockquote>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.BackslashLineBreak})
 }
 
 func TestInlineLink(t *testing.T) {
@@ -518,6 +1291,177 @@ func TestSafeInlineLink(t *testing.T) {
 	doSafeTestsInline(t, tests)
 }
 
+func TestSafeSchemes(t *testing.T) {
+	var tests = []string{
+		"[call](tel:+1234)\n",
+		"<p><a href=\"tel:+1234\">call</a></p>\n",
+
+		"<app://open/item>\n",
+		"<p><a href=\"app://open/item\">app://open/item</a></p>\n",
+
+		"[open](APP://open/item)\n",
+		"<p><a href=\"APP://open/item\">open</a></p>\n",
+
+		"[foo](http://bar/)\n",
+		"<p><a href=\"http://bar/\">foo</a></p>\n",
+
+		// not in the list
+		"[foo](baz://bar/)\n",
+		"<p><tt>foo</tt></p>\n",
+
+		"[foo](javascript:void)\n",
+		"<p><tt>foo</tt></p>\n",
+
+		// a scheme alone is not a link
+		"[foo](tel:)\n",
+		"<p><tt>foo</tt></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		Flags: html.Safelink,
+		RendererOptions: html.RendererOptions{
+			SafeSchemes: []string{"tel", "app"},
+		},
+	})
+
+	tests = []string{
+		"[call](tel:+1234)\n",
+		"<p><tt>call</tt></p>\n",
+
+		"<app://open/item>\n",
+		"<p><tt>app://open/item</tt></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{Flags: html.Safelink})
+}
+
+func TestURLRewriter(t *testing.T) {
+	var tests = []string{
+		"![alt](http://example.com/a.png)\n",
+		"<p><img src=\"https://proxy.test/?u=http://example.com/a.png\" alt=\"alt\" /></p>\n",
+
+		"[foo](http://example.com/)\n",
+		"<p><a href=\"http://example.com/\">foo</a></p>\n",
+
+		"[foo](http://bad.test/x) ![img](http://bad.test/x.png)\n",
+		"<p><a>foo</a> <img alt=\"img\" /></p>\n",
+
+		"[![alt](/a.png)](/b)\n",
+		"<p><a href=\"/b\"><img src=\"https://proxy.test/?u=/a.png\" alt=\"alt\" /></a></p>\n",
+	}
+	rewrite := func(url []byte, isImage bool) []byte {
+		if bytes.HasPrefix(url, []byte("http://bad.test/")) {
+			return nil
+		}
+		if isImage {
+			return append([]byte("https://proxy.test/?u="), url...)
+		}
+		return url
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		RendererOptions: html.RendererOptions{URLRewriter: rewrite},
+	})
+}
+
+// markNode is a custom inline node for ==marked== text.
+type markNode struct {
+	ast.Container
+}
+
+func markInline(p *parser.Parser, data []byte, offset int) (int, ast.Node) {
+	data = data[offset:]
+	if len(data) < 5 || data[1] != '=' || data[2] == '=' || data[2] == ' ' {
+		return 0, nil
+	}
+	end := bytes.Index(data[2:], []byte("=="))
+	if end <= 0 {
+		return 0, nil
+	}
+	node := &markNode{}
+	p.Inline(node, data[2:2+end])
+	return end + 4, node
+}
+
+func renderMark(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	if _, ok := node.(*markNode); !ok {
+		return ast.GoToNext, false
+	}
+	if entering {
+		io.WriteString(w, "<mark>")
+	} else {
+		io.WriteString(w, "</mark>")
+	}
+	return ast.GoToNext, true
+}
+
+func TestRegisterInline(t *testing.T) {
+	var tests = []string{
+		"a ==marked *text*== b\n",
+		"<p>a <mark>marked <em>text</em></mark> b</p>\n",
+
+		"a == b and ==c\n",
+		"<p>a == b and ==c</p>\n",
+
+		"*still* **works**\n",
+		"<p><em>still</em> <strong>works</strong></p>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		p := parser.NewWithExtensions(parser.CommonExtensions)
+		p.RegisterInline('=', markInline)
+		r := html.NewRenderer(html.RendererOptions{RenderNodeHook: renderMark})
+		got := string(ToHTML([]byte(tests[i]), p, r))
+		if got != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nGot     [%#v]\n", tests[i], tests[i+1], got)
+		}
+	}
+
+	// a custom parser for a byte with a built-in one falls back to it
+	tests = []string{
+		"*!* and *emph*\n",
+		"<p>! and <em>emph</em></p>\n",
+	}
+	bang := func(p *parser.Parser, data []byte, offset int) (int, ast.Node) {
+		if bytes.HasPrefix(data[offset:], []byte("*!*")) {
+			return 3, &ast.Text{Leaf: ast.Leaf{Literal: []byte("!")}}
+		}
+		return 0, nil
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		p := parser.NewWithExtensions(parser.CommonExtensions)
+		p.RegisterInline('*', bang)
+		got := string(ToHTML([]byte(tests[i]), p, nil))
+		if got != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nGot     [%#v]\n", tests[i], tests[i+1], got)
+		}
+	}
+}
+
+func TestInlineFallback(t *testing.T) {
+	var tests = []string{
+		"hello %name%, *welcome*\n",
+		"<p>hello Gopher, <em>welcome</em></p>\n",
+
+		"100% sure, %unknown%\n",
+		"<p>100% sure, %unknown%</p>\n",
+
+		// bytes with a built-in parser don't reach the fallback
+		"`%name%`\n",
+		"<p><code>%name%</code></p>\n",
+	}
+	vars := func(p *parser.Parser, data []byte, offset int) (int, ast.Node) {
+		if bytes.HasPrefix(data[offset:], []byte("%name%")) {
+			return len("%name%"), &ast.Text{Leaf: ast.Leaf{Literal: []byte("Gopher")}}
+		}
+		return 0, nil
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		p := parser.NewWithExtensions(parser.CommonExtensions)
+		p.InlineFallback = vars
+		got := string(ToHTML([]byte(tests[i]), p, nil))
+		if got != tests[i+1] {
+			t.Errorf("\nInput   [%#v]\nExpected[%#v]\nGot     [%#v]\n", tests[i], tests[i+1], got)
+		}
+	}
+}
+
 func TestReferenceLink(t *testing.T) {
 	var tests = []string{
 		"[link][ref]\n",
@@ -556,6 +1500,46 @@ func TestReferenceLink(t *testing.T) {
 	doLinkTestsInline(t, tests)
 }
 
+func TestCollapsedReferenceLink(t *testing.T) {
+	var tests = []string{
+		"[Google][]\n\n[google]: http://www.google.com/\n",
+		"<p><a href=\"http://www.google.com/\">Google</a></p>\n",
+
+		"[GOOGLE][] search\n\n[Google]: http://www.google.com/ \"Search\"\n",
+		"<p><a href=\"http://www.google.com/\" title=\"Search\">GOOGLE</a> search</p>\n",
+
+		"[Google][]\n",
+		"<p>[Google][]</p>\n",
+	}
+	doLinkTestsInline(t, tests)
+}
+
+func TestReferenceTitleAtEndOfInput(t *testing.T) {
+	var tests = []string{
+		"[a][1]\n\n[1]: /url \"t\"",
+		"<p><a href=\"/url\" title=\"t\">a</a></p>\n",
+
+		"[a][1]\n\n[1]: /url \"t\"\n",
+		"<p><a href=\"/url\" title=\"t\">a</a></p>\n",
+
+		"[a][1]\n\n[1]: /url\n  \"t\"",
+		"<p><a href=\"/url\" title=\"t\">a</a></p>\n",
+
+		"[a][1]\n\n[1]: /url (t)  ",
+		"<p><a href=\"/url\" title=\"t\">a</a></p>\n",
+
+		"[a][1]\n\n[1]: /url \"\"",
+		"<p><a href=\"/url\">a</a></p>\n",
+
+		"[a][1]\n\n[1]: /url \"",
+		"<p>[a][1]</p>\n\n<p>[1]: /url &quot;</p>\n",
+
+		"[a][1]\n\n[1]: /url \"t",
+		"<p>[a][1]</p>\n\n<p>[1]: /url &quot;t</p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
 func TestTags(t *testing.T) {
 	var tests = []string{
 		"a <span>tag</span>\n",
@@ -629,12 +1613,33 @@ func TestAutoLink(t *testing.T) {
 		"an email <some@one.com>\n",
 		"<p>an email <a href=\"mailto:some@one.com\">some@one.com</a></p>\n",
 
+		"an email <a@b.com>\n",
+		"<p>an email <a href=\"mailto:a@b.com\">a@b.com</a></p>\n",
+
+		"not an email <a@b>\n",
+		"<p>not an email <a@b></p>\n",
+
+		"not an email <a.b@c>\n",
+		"<p>not an email <a.b@c></p>\n",
+
 		"an ftp <ftp://old.com>\n",
 		"<p>an ftp <a href=\"ftp://old.com\">ftp://old.com</a></p>\n",
 
 		"an ftp <ftp:old.com>\n",
 		"<p>an ftp <a href=\"ftp:old.com\">ftp:old.com</a></p>\n",
 
+		"a chat <irc://irc.example.com/go>\n",
+		"<p>a chat <a href=\"irc://irc.example.com/go\">irc://irc.example.com/go</a></p>\n",
+
+		"any scheme <my+app.v2://open>\n",
+		"<p>any scheme <a href=\"my+app.v2://open\">my+app.v2://open</a></p>\n",
+
+		"not a url <not a url>\n",
+		"<p>not a url <not a url></p>\n",
+
+		"not a url either <a b:c>\n",
+		"<p>not a url either <a b:c></p>\n",
+
 		"a link with <http://new.com?query=foo&bar>\n",
 		"<p>a link with <a href=\"http://new.com?query=foo&amp;bar\">" +
 			"http://new.com?query=foo&amp;bar</a></p>\n",
@@ -726,9 +1731,7 @@ No longer in the footnote
 
 <p>Paragraph 2</p>
 
-<p><code>
-some code
-</code></p>
+<p><code>some code</code></p>
 
 <p>Paragraph 3</p></li>
 </ol>
@@ -890,9 +1893,9 @@ what happens here
 <hr />
 
 <ol>
-<li id="fn:a">This is the first note<sup class="footnote-ref" id="fnref:a"><a href="#fn:a">1</a></sup>.</li>
+<li id="fn:a">This is the first note<sup class="footnote-ref" id="fnref:a:2"><a href="#fn:a">1</a></sup>.</li>
 
-<li id="fn:b">this is the second note.<sup class="footnote-ref" id="fnref:a"><a href="#fn:a">1</a></sup></li>
+<li id="fn:b">this is the second note.<sup class="footnote-ref" id="fnref:a:3"><a href="#fn:a">1</a></sup></li>
 </ol>
 
 </div>
@@ -917,7 +1920,16 @@ func TestFootnotesWithParameters(t *testing.T) {
 		if i%2 == 1 {
 			test = strings.Replace(test, "fn:", "fn:"+prefix, -1)
 			test = strings.Replace(test, "fnref:", "fnref:"+prefix, -1)
-			test = re.ReplaceAllString(test, `<li id="fn:$1">$2 <a class="footnote-return" href="#fnref:$1">ret</a></li>`)
+			test = re.ReplaceAllStringFunc(test, func(li string) string {
+				m := re.FindStringSubmatch(li)
+				// a return link to each reference
+				links := ` <a class="footnote-return" href="#fnref:` + m[1] + `">ret</a>`
+				refs := strings.Count(test, `id="fnref:`+m[1]+`:`)
+				for n := 2; n <= refs+1; n++ {
+					links += fmt.Sprintf(` <a class="footnote-return" href="#fnref:%s:%d">ret</a>`, m[1], n)
+				}
+				return `<li id="fn:` + m[1] + `">` + m[2] + links + `</li>`
+			})
 		}
 		tests[i] = test
 	}
@@ -934,6 +1946,42 @@ func TestFootnotesWithParameters(t *testing.T) {
 	})
 }
 
+func TestFootnoteBackReferences(t *testing.T) {
+	tests := []string{
+		"a[^n] b[^n] c[^m]\n\n[^n]: note\n[^m]: other\n",
+		"<p>a<sup class=\"footnote-ref\" id=\"fnref:n\"><a href=\"#fn:n\">1</a></sup> " +
+			"b<sup class=\"footnote-ref\" id=\"fnref:n:2\"><a href=\"#fn:n\">1</a></sup> " +
+			"c<sup class=\"footnote-ref\" id=\"fnref:m\"><a href=\"#fn:m\">2</a></sup></p>\n\n" +
+			"<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n" +
+			"<li id=\"fn:n\">note <a class=\"footnote-return\" href=\"#fnref:n\">↩</a> <a class=\"footnote-return\" href=\"#fnref:n:2\">↩</a></li>\n\n" +
+			"<li id=\"fn:m\">other <a class=\"footnote-return\" href=\"#fnref:m\">↩</a></li>\n" +
+			"</ol>\n\n</div>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.Footnotes,
+		Flags:      html.FootnoteReturnLinks,
+		RendererOptions: html.RendererOptions{
+			FootnoteReturnLinkContents: "↩",
+		},
+	})
+
+	tests = []string{
+		"a[^n]\n\n[^n]: note\n",
+		"<p>a<sup class=\"footnote-ref\" id=\"doc1-ref:n\"><a href=\"#doc1-note:n\">1</a></sup></p>\n\n" +
+			"<div class=\"footnotes\">\n\n<hr />\n\n<ol>\n" +
+			"<li id=\"doc1-note:n\">note <a class=\"footnote-return\" href=\"#doc1-ref:n\"><sup>[return]</sup></a></li>\n" +
+			"</ol>\n\n</div>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{
+		extensions: parser.Footnotes,
+		Flags:      html.FootnoteReturnLinks,
+		RendererOptions: html.RendererOptions{
+			FootnoteIDPrefix:    "doc1-note",
+			FootnoteRefIDPrefix: "doc1-ref",
+		},
+	})
+}
+
 func TestNestedFootnotes(t *testing.T) {
 	var tests = []string{
 		`Paragraph.[^fn1]
@@ -978,11 +2026,11 @@ This uses footnote C.[^C]
 <hr />
 
 <ol>
-<li id="fn:A">A note. use itself.<sup class="footnote-ref" id="fnref:A"><a href="#fn:A">1</a></sup></li>
+<li id="fn:A">A note. use itself.<sup class="footnote-ref" id="fnref:A:2"><a href="#fn:A">1</a></sup></li>
 
 <li id="fn:C">C note, uses B.<sup class="footnote-ref" id="fnref:B"><a href="#fn:B">3</a></sup></li>
 
-<li id="fn:B">B note, uses A to test duplicate.<sup class="footnote-ref" id="fnref:A"><a href="#fn:A">1</a></sup></li>
+<li id="fn:B">B note, uses A to test duplicate.<sup class="footnote-ref" id="fnref:A:3"><a href="#fn:A">1</a></sup></li>
 </ol>
 
 </div>
@@ -1121,6 +2169,26 @@ func TestDisableSmartDashes(t *testing.T) {
 	}, TestParams{Flags: html.Smartypants | html.SmartypantsLatexDashes})
 }
 
+func TestValidateEntities(t *testing.T) {
+	var tests = []string{
+		"&copy; 2020\n",
+		"<p>© 2020</p>\n",
+
+		"&#65;&#x42;&#X43;\n",
+		"<p>ABC</p>\n",
+
+		"&lt;tag&gt; &amp; &quot;\n",
+		"<p>&lt;tag&gt; &amp; &quot;</p>\n",
+
+		"&#0; &#xD800;\n",
+		"<p>\uFFFD \uFFFD</p>\n",
+
+		"&bogus; &copyx; &#xZZ;\n",
+		"<p>&amp;bogus; &amp;copyx; &amp;#xZZ;</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.ValidateEntities})
+}
+
 func TestSkipLinks(t *testing.T) {
 	doTestsInlineParam(t, []string{
 		"[foo](gopher://foo.bar)",
@@ -1142,6 +2210,96 @@ func TestSkipImages(t *testing.T) {
 	})
 }
 
+func TestImageFigures(t *testing.T) {
+	var tests = []string{
+		"![alt](/a.png \"A caption\")\n",
+		"<figure><img src=\"/a.png\" alt=\"alt\" title=\"A caption\" /><figcaption>A caption</figcaption></figure>\n",
+
+		"![alt](/a.png \"Fish & <chips>\")\n",
+		"<figure><img src=\"/a.png\" alt=\"alt\" title=\"Fish &amp; &lt;chips&gt;\" /><figcaption>Fish &amp; &lt;chips&gt;</figcaption></figure>\n",
+
+		// no title, no figure
+		"![alt](/a.png)\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" /></p>\n",
+
+		// inline within text
+		"See ![alt](/a.png \"A caption\") here\n",
+		"<p>See <img src=\"/a.png\" alt=\"alt\" title=\"A caption\" /> here</p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{Flags: html.ImageFigures})
+
+	tests = []string{
+		"![alt](/a.png \"A caption\")\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" title=\"A caption\" /></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{})
+}
+
+func TestPercentEncodeURLs(t *testing.T) {
+	var tests = []string{
+		"[link](/a b)\n",
+		"<p><a href=\"/a%20b\">link</a></p>\n",
+
+		"[link](/a%20b)\n",
+		"<p><a href=\"/a%20b\">link</a></p>\n",
+
+		"[link](</my file.html?q=a b&x=\"y\">)\n",
+		"<p><a href=\"/my%20file.html?q=a%20b&amp;x=%22y%22\">link</a></p>\n",
+
+		"[link](/100%/é)\n",
+		"<p><a href=\"/100%25/%C3%A9\">link</a></p>\n",
+
+		"![img](/my image.png)\n",
+		"<p><img src=\"/my%20image.png\" alt=\"img\" /></p>\n",
+
+		"<http://example.com/a%2Fb?x=1&y=[2]>\n",
+		"<p><a href=\"http://example.com/a%2Fb?x=1&amp;y=[2]\">http://example.com/a%2Fb?x=1&amp;y=[2]</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{Flags: html.PercentEncodeURLs})
+
+	// on by default
+	got := string(ToHTML([]byte("[link](/a b)\n"), nil, nil))
+	if want := "<p><a href=\"/a%20b\">link</a></p>\n"; got != want {
+		t.Errorf("ToHTML: got %q, want %q", got, want)
+	}
+}
+
+func TestImageSize(t *testing.T) {
+	var tests = []string{
+		"![alt](/a.png =100x200)\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" width=\"100\" height=\"200\" /></p>\n",
+
+		"![alt](/a.png =100x \"title\")\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" title=\"title\" width=\"100\" /></p>\n",
+
+		"![alt](/a.png =x200)\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" height=\"200\" /></p>\n",
+
+		// not size hints
+		"![alt](/a.png =axb)\n",
+		"<p><img src=\"/a.png =axb\" alt=\"alt\" /></p>\n",
+
+		"![alt](/a.png =x)\n",
+		"<p><img src=\"/a.png =x\" alt=\"alt\" /></p>\n",
+
+		"[link](/a =100x200)\n",
+		"<p><a href=\"/a =100x200\">link</a></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.ImageSize})
+
+	tests = []string{
+		"![alt](/a.png)\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" loading=\"lazy\" decoding=\"async\" /></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{Flags: html.LazyImages | html.AsyncImages})
+
+	tests = []string{
+		"![alt](/a.png =100x200)\n",
+		"<p><img src=\"/a.png\" alt=\"alt\" width=\"100\" height=\"200\" loading=\"lazy\" /></p>\n",
+	}
+	doTestsInlineParam(t, tests, TestParams{extensions: parser.ImageSize, Flags: html.LazyImages})
+}
+
 func TestUseXHTML(t *testing.T) {
 	doTestsParam(t, []string{
 		"---",
@@ -1156,21 +2314,102 @@ func TestUseXHTML(t *testing.T) {
 func TestSkipHTML(t *testing.T) {
 	doTestsParam(t, []string{
 		"<div class=\"foo\"></div>\n\ntext\n\n<form>the form</form>",
-		"<p>text</p>\n\n<p>the form</p>\n",
+		"<p>text</p>\n",
+
+		"text\n\n<form>the form</form> text",
+		"<p>text</p>\n\n<p>the form text</p>\n",
 
 		"text <em>inline html</em> more text",
 		"<p>text inline html more text</p>\n",
 	}, TestParams{Flags: html.SkipHTML})
 }
 
+func TestInlineHTML(t *testing.T) {
+	input := "<div>block</div>\n\ntext <b>bold</b> <!-- note --> more\n"
+	doTestsParam(t, []string{
+		input,
+		"<div>block</div>\n\n<p>text bold  more</p>\n",
+	}, TestParams{Flags: html.SkipInlineHTML})
+
+	doTestsParam(t, []string{
+		input,
+		"<div>block</div>\n\n<p>text &lt;b&gt;bold&lt;/b&gt; &lt;!-- note --&gt; more</p>\n",
+	}, TestParams{Flags: html.EscapeInlineHTML})
+
+	// the inline flags take precedence over SkipHTML, which still skips
+	// block HTML
+	doTestsParam(t, []string{
+		input,
+		"<p>text &lt;b&gt;bold&lt;/b&gt; &lt;!-- note --&gt; more</p>\n",
+	}, TestParams{Flags: html.EscapeInlineHTML | html.SkipHTML})
+}
+
+func TestSkipHTMLComments(t *testing.T) {
+	tests := []string{
+		"a <!-- editorial note --> b\n",
+		"<p>a  b</p>\n",
+
+		"<!-- block\ncomment -->\n\ntext\n",
+		"<p>text</p>\n",
+
+		"<div>\n<!-- in div -->x\n</div>\n",
+		"<div>\nx\n</div>\n",
+
+		"a <b>bold</b> c\n",
+		"<p>a <b>bold</b> c</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{Flags: html.SkipHTMLComments})
+
+	// comments are kept by default
+	tests = []string{
+		"a <!-- editorial note --> b\n",
+		"<p>a <!-- editorial note --> b</p>\n",
+
+		"<!-- block\ncomment -->\n\ntext\n",
+		"<!-- block\ncomment -->\n\n<p>text</p>\n",
+	}
+	doTestsParam(t, tests, TestParams{})
+}
+
 func TestInlineMath(t *testing.T) {
 	doTestsParam(t, []string{
 		"$a_b$",
 		`<p><span class="math inline">\(a_b\)</span></p>
 `,
+
+		"where $x = 2$ and $y$.",
+		`<p>where <span class="math inline">\(x = 2\)</span> and <span class="math inline">\(y\)</span>.</p>
+`,
+
+		// currency isn't math
+		"it costs $5 and $10.",
+		"<p>it costs $5 and $10.</p>\n",
+
+		"a $ 20$ and $20 $ b",
+		"<p>a $ 20$ and $20 $ b</p>\n",
+
+		"$a$1 and $b$",
+		`<p><span class="math inline">\(a$1 and $b\)</span></p>
+`,
 	}, TestParams{Flags: html.SkipHTML, extensions: parser.CommonExtensions})
 }
 
+// unmatched $ used to scan to the end of the text each, which was quadratic
+func TestInlineMathUnmatchedIsLinear(t *testing.T) {
+	input := []byte(strings.Repeat("$a ", 100000))
+	c := make(chan bool, 1)
+	go func() {
+		p := parser.NewWithExtensions(parser.CommonExtensions)
+		ToHTML(input, p, nil)
+		c <- true
+	}()
+	select {
+	case <-c:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out parsing %d unmatched $", 100000)
+	}
+}
+
 func BenchmarkSmartDoubleQuotes(b *testing.B) {
 	params := TestParams{Flags: html.Smartypants}
 	params.extensions |= parser.Autolink | parser.Strikethrough
@@ -1180,3 +2419,31 @@ func BenchmarkSmartDoubleQuotes(b *testing.B) {
 		runMarkdown("this should be normal \"quoted\" text.\n", params)
 	}
 }
+
+func TestReferenceCaseFolding(t *testing.T) {
+	var tests = []string{
+		"[a][ÉTÉ]\n\n[été]: /url\n",
+		"<p><a href=\"/url\">a</a></p>\n",
+
+		"[Été]\n\n[ÉTÉ]: /url\n",
+		"<p><a href=\"/url\">Été</a></p>\n",
+
+		"[a][ΣΑΣ]\n\n[σας]: /url\n",
+		"<p><a href=\"/url\">a</a></p>\n",
+
+		"[a][ſ]\n\n[S]: /url\n",
+		"<p><a href=\"/url\">a</a></p>\n",
+	}
+	doTestsInline(t, tests)
+}
+
+func TestDuplicateReference(t *testing.T) {
+	var tests = []string{
+		"[a][1]\n\n[1]: /first\n[1]: /second\n",
+		"<p><a href=\"/first\">a</a></p>\n",
+
+		"[a][x]\n\n[X]: /first \"one\"\n\n[x]: /second \"two\"\n",
+		"<p><a href=\"/first\" title=\"one\">a</a></p>\n",
+	}
+	doTestsInline(t, tests)
+}
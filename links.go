@@ -0,0 +1,64 @@
+package markdown
+
+import (
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// Link is a link of a markdown document, as returned by ExtractLinks.
+type Link struct {
+	URL   string
+	Title string
+	Text  string // text of the link without markup
+}
+
+// Image is an image of a markdown document, as returned by ExtractImages.
+type Image struct {
+	URL   string
+	Title string
+	Alt   string
+}
+
+// ExtractLinks returns the links of a markdown document in order: inline,
+// reference style and autolinks, but not footnote references. It's meant for
+// e.g. link checkers.
+func ExtractLinks(input []byte) []Link {
+	var links []Link
+	walkParsed(input, func(node ast.Node) {
+		if link, ok := node.(*ast.Link); ok && link.NoteID == 0 {
+			links = append(links, Link{
+				URL:   string(link.Destination),
+				Title: string(link.Title),
+				Text:  plainText(link),
+			})
+		}
+	})
+	return links
+}
+
+// ExtractImages returns the images of a markdown document in order.
+func ExtractImages(input []byte) []Image {
+	var images []Image
+	walkParsed(input, func(node ast.Node) {
+		if image, ok := node.(*ast.Image); ok {
+			images = append(images, Image{
+				URL:   string(image.Destination),
+				Title: string(image.Title),
+				Alt:   plainText(image),
+			})
+		}
+	})
+	return images
+}
+
+// walkParsed parses input with parser.CommonExtensions and calls fn when
+// entering every node.
+func walkParsed(input []byte, fn func(node ast.Node)) {
+	doc := parser.New().Parse(input)
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if entering {
+			fn(node)
+		}
+		return ast.GoToNext
+	})
+}
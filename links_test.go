@@ -0,0 +1,49 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractLinks(t *testing.T) {
+	input := []byte(`An [inline](/inline "Inline title") link, a [*reference*][ref],
+a [shortcut] and an autolink http://example.com/auto and <https://example.com/angle>.
+
+A footnote[^1] and ![an image](/img.png) aren't links, [an ![image](/in.png) link](/outer) is.
+
+[ref]: https://example.com/ref 'Ref title'
+[shortcut]: /shortcut
+[^1]: the note
+`)
+	want := []Link{
+		{"/inline", "Inline title", "inline"},
+		{"https://example.com/ref", "Ref title", "reference"},
+		{"/shortcut", "", "shortcut"},
+		{"http://example.com/auto", "", "http://example.com/auto"},
+		{"https://example.com/angle", "", "https://example.com/angle"},
+		{"/outer", "", "an image link"},
+	}
+	if got := ExtractLinks(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("got links\n%+v\nwant\n%+v", got, want)
+	}
+
+	if got := ExtractLinks([]byte("no links\n")); got != nil {
+		t.Errorf("got %+v for no links", got)
+	}
+}
+
+func TestExtractImages(t *testing.T) {
+	input := []byte(`![inline](/a.png "A title") and ![reference][img]
+and [a ![linked](/b.png) image](/page).
+
+[img]: https://example.com/c.png
+`)
+	want := []Image{
+		{"/a.png", "A title", "inline"},
+		{"https://example.com/c.png", "", "reference"},
+		{"/b.png", "", "linked"},
+	}
+	if got := ExtractImages(input); !reflect.DeepEqual(got, want) {
+		t.Errorf("got images\n%+v\nwant\n%+v", got, want)
+	}
+}
@@ -2,7 +2,10 @@ package markdown
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"strings"
 
 	"github.com/gomarkdown/markdown/ast"
 	"github.com/gomarkdown/markdown/html"
@@ -41,6 +44,23 @@ type Renderer interface {
 	RenderFooter(w io.Writer, ast ast.Node)
 }
 
+// postProcessor is implemented by renderers with a function transforming
+// their whole output, like html.Renderer with RendererOptions.PostProcess.
+type postProcessor interface {
+	PostProcessor() func(output []byte) []byte
+}
+
+// postProcess returns output transformed by the post-processing function of
+// renderer, if any.
+func postProcess(renderer Renderer, output []byte) []byte {
+	if pp, ok := renderer.(postProcessor); ok {
+		if fn := pp.PostProcessor(); fn != nil {
+			return fn(output)
+		}
+	}
+	return output
+}
+
 // Parse parsers a markdown document using provided parser. If parser is nil,
 // we use parser configured with parser.CommonExtensions.
 //
@@ -63,7 +83,47 @@ func Render(doc ast.Node, renderer Renderer) []byte {
 		return renderer.RenderNode(&buf, node, entering)
 	})
 	renderer.RenderFooter(&buf, doc)
-	return buf.Bytes()
+	return postProcess(renderer, buf.Bytes())
+}
+
+// RenderTo is like Render but writes the output to w as it goes instead of
+// returning it. Each top-level block is written to w as soon as it has been
+// rendered, so only the document tree and the output of a single block are
+// held in memory, which helps with very large documents. The first error
+// returned by w stops the rendering and is returned.
+//
+// If the renderer post-processes its output, the whole output is rendered
+// before being written.
+func RenderTo(w io.Writer, doc ast.Node, renderer Renderer) error {
+	if pp, ok := renderer.(postProcessor); ok && pp.PostProcessor() != nil {
+		_, err := w.Write(Render(doc, renderer))
+		return err
+	}
+	var buf bytes.Buffer
+	var err error
+	flush := func() {
+		if err == nil && buf.Len() > 0 {
+			_, err = w.Write(buf.Bytes())
+		}
+		buf.Reset()
+	}
+	renderer.RenderHeader(&buf, doc)
+	flush()
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if err != nil {
+			return ast.Terminate
+		}
+		status := renderer.RenderNode(&buf, node, entering)
+		// a top-level block is done when its container is left or, for
+		// leaves, right away
+		if node.GetParent() == doc && (!entering || node.AsContainer() == nil) {
+			flush()
+		}
+		return status
+	})
+	renderer.RenderFooter(&buf, doc)
+	flush()
+	return err
 }
 
 // ToHTML converts markdownDoc to HTML.
@@ -83,3 +143,191 @@ func ToHTML(markdown []byte, p *parser.Parser, renderer Renderer) []byte {
 	}
 	return Render(doc, renderer)
 }
+
+// ToHTMLContext is like ToHTML but gives up when ctx is canceled or its
+// deadline passes, returning ctx.Err(). Use it to bound the time spent on
+// untrusted input.
+func ToHTMLContext(ctx context.Context, markdown []byte, p *parser.Parser, renderer Renderer) ([]byte, error) {
+	if p == nil {
+		p = parser.New()
+	}
+	doc, err := p.ParseContext(ctx, markdown)
+	if err != nil {
+		return nil, err
+	}
+	if renderer == nil {
+		opts := html.RendererOptions{
+			Flags: html.CommonFlags,
+		}
+		renderer = html.NewRenderer(opts)
+	}
+
+	var buf bytes.Buffer
+	renderer.RenderHeader(&buf, doc)
+	nodes := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		// checking the context is relatively expensive, don't do it for
+		// every node
+		nodes++
+		if nodes%256 == 0 && ctx.Err() != nil {
+			return ast.Terminate
+		}
+		return renderer.RenderNode(&buf, node, entering)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	renderer.RenderFooter(&buf, doc)
+	return postProcess(renderer, buf.Bytes()), nil
+}
+
+// ToHTMLBytes converts markdown to HTML using the defaults of ToHTML: a parser
+// configured with parser.CommonExtensions and html.Renderer configured with
+// html.CommonFlags.
+func ToHTMLBytes(markdown []byte) []byte {
+	return ToHTML(markdown, nil, nil)
+}
+
+// ToHTMLString is like ToHTMLBytes but takes and returns a string.
+func ToHTMLString(markdown string) string {
+	return string(ToHTMLBytes([]byte(markdown)))
+}
+
+// ToHTMLSafe converts markdown from an untrusted source to HTML that is safe
+// to embed in a page. It's like ToHTMLBytes, but raw HTML is stripped of tags
+// and attributes not in html.DefaultAllowedHTML (html.SanitizeHTML), links
+// to untrusted protocols like javascript: are dropped (html.Safelink) and so
+// are the sources of images that aren't relative or http(s) URLs.
+func ToHTMLSafe(markdown []byte) []byte {
+	opts := html.RendererOptions{
+		Flags:       html.CommonFlags | html.SanitizeHTML | html.Safelink,
+		URLRewriter: safeImageURL,
+	}
+	return ToHTML(markdown, nil, html.NewRenderer(opts))
+}
+
+// MarkdownEPUB converts markdown to an EPUB 3 XHTML content document with
+// the given title. Each top-level heading and what follows it is a chapter in
+// a <section epub:type="chapter">, headings get ids generated from their text
+// and raw HTML is stripped of the tags not in html.DefaultAllowedHTML and
+// rewritten as well-formed XHTML (html.EPUB). Links are kept as is, so that
+// they can point to other documents of the book.
+func MarkdownEPUB(markdown []byte, title string) []byte {
+	p := parser.NewWithExtensions(parser.CommonExtensions | parser.AutoHeadingIDs | parser.Footnotes)
+	opts := html.RendererOptions{
+		Flags: html.CommonFlags | html.EPUB | html.CompletePage | html.SanitizeHTML,
+		Title: title,
+	}
+	return ToHTML(markdown, p, html.NewRenderer(opts))
+}
+
+// safeImageURL drops image sources with a scheme other than http and https.
+// Links are checked by the Safelink flag.
+func safeImageURL(url []byte, isImage bool) []byte {
+	if !isImage {
+		return url
+	}
+	i := bytes.IndexAny(url, ":/?#")
+	if i < 0 || url[i] != ':' {
+		// relative
+		return url
+	}
+	switch strings.ToLower(string(url[:i])) {
+	case "http", "https":
+		return url
+	}
+	return nil
+}
+
+var (
+	// ErrInputTooLarge is returned when the input is larger than
+	// Limits.MaxInputSize.
+	ErrInputTooLarge = errors.New("markdown: input too large")
+	// ErrOutputTooLarge is returned when the rendered output grows past
+	// Limits.MaxOutputSize.
+	ErrOutputTooLarge = errors.New("markdown: output too large")
+	// ErrTooManySteps is returned when parsing takes more than
+	// Limits.MaxSteps steps.
+	ErrTooManySteps = parser.ErrTooManySteps
+)
+
+// Limits bounds the amount of work done when converting untrusted input.
+// The zero value means no limits.
+type Limits struct {
+	// MaxInputSize, if > 0, is the largest input (in bytes) that will be
+	// parsed.
+	MaxInputSize int
+	// MaxOutputSize, if > 0, is the largest output (in bytes) that will be
+	// rendered. Rendering is aborted as soon as the output grows past it.
+	MaxOutputSize int
+	// MaxSteps, if > 0, bounds the work done parsing, see
+	// parser.Options.MaxSteps. It protects against inputs crafted to make
+	// parsing slow, which a small MaxInputSize doesn't rule out.
+	MaxSteps int
+}
+
+// RenderWithLimits is like Render but aborts with ErrOutputTooLarge when
+// the output grows past limits.MaxOutputSize. If the renderer post-processes
+// its output, both the output as it's rendered and the post-processed output
+// must fit.
+func RenderWithLimits(doc ast.Node, renderer Renderer, limits Limits) ([]byte, error) {
+	var buf bytes.Buffer
+	tooLarge := func() bool {
+		return limits.MaxOutputSize > 0 && buf.Len() > limits.MaxOutputSize
+	}
+	renderer.RenderHeader(&buf, doc)
+	if tooLarge() {
+		return nil, ErrOutputTooLarge
+	}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if tooLarge() {
+			return ast.Terminate
+		}
+		return renderer.RenderNode(&buf, node, entering)
+	})
+	if tooLarge() {
+		return nil, ErrOutputTooLarge
+	}
+	renderer.RenderFooter(&buf, doc)
+	if tooLarge() {
+		return nil, ErrOutputTooLarge
+	}
+	output := postProcess(renderer, buf.Bytes())
+	if limits.MaxOutputSize > 0 && len(output) > limits.MaxOutputSize {
+		return nil, ErrOutputTooLarge
+	}
+	return output, nil
+}
+
+// ToHTMLWithLimits is like ToHTML but enforces limits. It returns
+// ErrInputTooLarge without parsing if markdown is larger than
+// limits.MaxInputSize, ErrTooManySteps if parsing takes more than
+// limits.MaxSteps steps and ErrOutputTooLarge if the HTML would be larger than
+// limits.MaxOutputSize. limits.MaxSteps overrides p.Opts.MaxSteps while
+// parsing, p.Opts is left unchanged.
+//
+// The markdown is parsed with p.ParseContext, so instead of panicking on an
+// internal error of the parser, ToHTMLWithLimits returns a *parser.Error.
+func ToHTMLWithLimits(markdown []byte, p *parser.Parser, renderer Renderer, limits Limits) ([]byte, error) {
+	if limits.MaxInputSize > 0 && len(markdown) > limits.MaxInputSize {
+		return nil, ErrInputTooLarge
+	}
+	if p == nil {
+		p = parser.New()
+	}
+	if limits.MaxSteps > 0 {
+		defer func(maxSteps int) { p.Opts.MaxSteps = maxSteps }(p.Opts.MaxSteps)
+		p.Opts.MaxSteps = limits.MaxSteps
+	}
+	doc, err := p.ParseContext(context.Background(), markdown)
+	if err != nil {
+		return nil, err
+	}
+	if renderer == nil {
+		opts := html.RendererOptions{
+			Flags: html.CommonFlags,
+		}
+		renderer = html.NewRenderer(opts)
+	}
+	return RenderWithLimits(doc, renderer, limits)
+}
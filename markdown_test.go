@@ -1,6 +1,19 @@
 package markdown
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+)
 
 func TestDocument(t *testing.T) {
 	var tests = []string{
@@ -11,6 +24,18 @@ func TestDocument(t *testing.T) {
 		" ",
 		"",
 
+		"\n",
+		"",
+
+		"   \n",
+		"",
+
+		"\r\n",
+		"",
+
+		"\n\r\n \t\r\n",
+		"",
+
 		// This shouldn't panic.
 		// https://github.com/russross/blackfriday/issues/172
 		"[]:<",
@@ -23,3 +48,314 @@ func TestDocument(t *testing.T) {
 	}
 	doTests(t, tests)
 }
+
+func TestToHTMLStringAndBytes(t *testing.T) {
+	inputs := []string{
+		"",
+		"# heading\n\nsome *text* -- \"quoted\"\n",
+		"* a\n* b\n\n| x | y |\n|---|---|\n| 1 | 2 |\n",
+	}
+	for _, input := range inputs {
+		exp := ToHTML([]byte(input), nil, nil)
+		if got := ToHTMLBytes([]byte(input)); !bytes.Equal(got, exp) {
+			t.Errorf("ToHTMLBytes(%q) = %q, want %q", input, got, exp)
+		}
+		if got := ToHTMLString(input); got != string(exp) {
+			t.Errorf("ToHTMLString(%q) = %q, want %q", input, got, exp)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	input := []byte("# heading\n\nsome *text*\n")
+	exp := ToHTML(input, nil, nil)
+
+	got, err := ToHTMLWithLimits(input, nil, nil, Limits{})
+	if err != nil || !bytes.Equal(got, exp) {
+		t.Errorf("no limits: got %q, %v, want %q", got, err, exp)
+	}
+
+	got, err = ToHTMLWithLimits(input, nil, nil, Limits{
+		MaxInputSize:  len(input),
+		MaxOutputSize: len(exp),
+	})
+	if err != nil || !bytes.Equal(got, exp) {
+		t.Errorf("exact limits: got %q, %v, want %q", got, err, exp)
+	}
+
+	_, err = ToHTMLWithLimits(input, nil, nil, Limits{MaxInputSize: len(input) - 1})
+	if err != ErrInputTooLarge {
+		t.Errorf("got error %v, want %v", err, ErrInputTooLarge)
+	}
+
+	_, err = ToHTMLWithLimits(input, nil, nil, Limits{MaxOutputSize: len(exp) - 1})
+	if err != ErrOutputTooLarge {
+		t.Errorf("got error %v, want %v", err, ErrOutputTooLarge)
+	}
+
+	// rendering stops early instead of producing the whole output
+	big := []byte(strings.Repeat("*a* ", 10000))
+	_, err = ToHTMLWithLimits(big, nil, nil, Limits{MaxOutputSize: 100})
+	if err != ErrOutputTooLarge {
+		t.Errorf("got error %v, want %v", err, ErrOutputTooLarge)
+	}
+
+	// parsing stops on input crafted to be slow
+	slow := []byte(strings.Repeat("*a ", 5000))
+	_, err = ToHTMLWithLimits(slow, nil, nil, Limits{MaxSteps: 100000})
+	if err != ErrTooManySteps {
+		t.Errorf("got error %v, want %v", err, ErrTooManySteps)
+	}
+
+	// the parser given keeps its own budget
+	p := parser.New()
+	_, err = ToHTMLWithLimits(slow, p, nil, Limits{MaxSteps: 100000})
+	if err != ErrTooManySteps || p.Opts.MaxSteps != 0 {
+		t.Errorf("got error %v and MaxSteps %d, want %v and 0", err, p.Opts.MaxSteps, ErrTooManySteps)
+	}
+
+	// internal parser errors are returned
+	p = parser.New()
+	p.RegisterBlock([]byte("!!! "), func(data []byte) (ast.Node, []byte, int) {
+		panic("broken hook")
+	})
+	_, err = ToHTMLWithLimits([]byte("!!! boom\n"), p, nil, Limits{})
+	if _, ok := err.(*parser.Error); !ok {
+		t.Errorf("got error %v, want *parser.Error", err)
+	}
+}
+
+type writesRecorder struct {
+	writes []string
+	err    error
+}
+
+func (w *writesRecorder) Write(d []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.writes = append(w.writes, string(d))
+	return len(d), nil
+}
+
+func TestRenderTo(t *testing.T) {
+	inputs := []string{
+		"",
+		"# heading\n\nsome *text*\n\n* a\n* b\n\n---\n\n    code\n",
+		"text[^1]\n\n| x | y |\n|---|---|\n| 1 | 2 |\n\n[^1]: a note\n",
+	}
+	flags := []html.Flags{html.CommonFlags, html.CompletePage | html.TOC}
+	for _, input := range inputs {
+		for _, f := range flags {
+			newRenderer := func() Renderer {
+				return html.NewRenderer(html.RendererOptions{Flags: f, Title: "t"})
+			}
+			exp := Render(Parse([]byte(input), nil), newRenderer())
+
+			var buf bytes.Buffer
+			err := RenderTo(&buf, Parse([]byte(input), nil), newRenderer())
+			if err != nil || !bytes.Equal(buf.Bytes(), exp) {
+				t.Errorf("RenderTo(%q) = %q, %v, want %q", input, buf.Bytes(), err, exp)
+			}
+		}
+	}
+
+	// every top-level block is written on its own
+	w := &writesRecorder{}
+	err := RenderTo(w, Parse([]byte("# heading\n\ntext\n\n---\n"), nil), html.NewRenderer(html.RendererOptions{}))
+	exp := []string{"<h1>heading</h1>\n", "\n<p>text</p>\n", "\n<hr>\n"}
+	if err != nil || strings.Join(w.writes, "|") != strings.Join(exp, "|") {
+		t.Errorf("got writes %q, %v, want %q", w.writes, err, exp)
+	}
+
+	w = &writesRecorder{err: io.ErrShortWrite}
+	err = RenderTo(w, Parse([]byte("text\n"), nil), html.NewRenderer(html.RendererOptions{}))
+	if err != io.ErrShortWrite {
+		t.Errorf("got error %v, want %v", err, io.ErrShortWrite)
+	}
+}
+
+var tagNameRe = regexp.MustCompile(`</?[a-z][a-z0-9]*`)
+
+func TestPostProcess(t *testing.T) {
+	calls := 0
+	upperTags := func(output []byte) []byte {
+		calls++
+		return tagNameRe.ReplaceAllFunc(output, bytes.ToUpper)
+	}
+	newRenderer := func() Renderer {
+		return html.NewRenderer(html.RendererOptions{
+			Flags:       html.CompletePage,
+			PostProcess: upperTags,
+		})
+	}
+	input := []byte("# heading\n\nsome *text*\n")
+	exp := "<H1>heading</H1>\n\n<P>some <EM>text</EM></P>\n\n</BODY>\n</HTML>\n"
+
+	out := ToHTML(input, nil, newRenderer())
+	if !strings.HasSuffix(string(out), exp) || !strings.HasPrefix(string(out), "<!DOCTYPE html>\n<HTML>\n<HEAD>") {
+		t.Errorf("ToHTML() = %q, want it to end with %q", out, exp)
+	}
+
+	var buf bytes.Buffer
+	err := RenderTo(&buf, Parse(input, nil), newRenderer())
+	if err != nil || !bytes.Equal(buf.Bytes(), out) {
+		t.Errorf("RenderTo() = %q, %v, want %q", buf.Bytes(), err, out)
+	}
+
+	got, err := ToHTMLContext(context.Background(), input, nil, newRenderer())
+	if err != nil || !bytes.Equal(got, out) {
+		t.Errorf("ToHTMLContext() = %q, %v, want %q", got, err, out)
+	}
+
+	got, err = ToHTMLWithLimits(input, nil, newRenderer(), Limits{MaxOutputSize: len(out)})
+	if err != nil || !bytes.Equal(got, out) {
+		t.Errorf("ToHTMLWithLimits() = %q, %v, want %q", got, err, out)
+	}
+
+	got, err = RenderWithLimits(Parse(input, nil), newRenderer(), Limits{})
+	if err != nil || !bytes.Equal(got, out) {
+		t.Errorf("RenderWithLimits() = %q, %v, want %q", got, err, out)
+	}
+	if calls != 5 {
+		t.Errorf("PostProcess called %d times, want 5", calls)
+	}
+
+	// the limit applies to the post-processed output
+	growing := html.NewRenderer(html.RendererOptions{
+		PostProcess: func(output []byte) []byte {
+			return append(output, "<!-- footer -->\n"...)
+		},
+	})
+	plain := ToHTML(input, nil, nil)
+	_, err = ToHTMLWithLimits(input, nil, growing, Limits{MaxOutputSize: len(plain)})
+	if err != ErrOutputTooLarge {
+		t.Errorf("got error %v, want %v", err, ErrOutputTooLarge)
+	}
+}
+
+// cancelAfter is a context that gets canceled after Err has been called n
+// times, to cancel in the middle of parsing or rendering
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestToHTMLContext(t *testing.T) {
+	input := []byte(strings.Repeat("# heading\n\nsome *text*\n\n> quote\n\n", 1000))
+	exp := ToHTML(input, nil, nil)
+
+	got, err := ToHTMLContext(context.Background(), input, nil, nil)
+	if err != nil || !bytes.Equal(got, exp) {
+		t.Errorf("got %d bytes, %v, want %d bytes", len(got), err, len(exp))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got, err = ToHTMLContext(ctx, input, nil, nil); err != context.Canceled || got != nil {
+		t.Errorf("canceled context: got %d bytes, %v", len(got), err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	if _, err = ToHTMLContext(ctx, input, nil, nil); err != context.DeadlineExceeded {
+		t.Errorf("expired context: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// canceled while parsing blocks, inline markdown and while rendering
+	for _, n := range []int{2, 60, 100} {
+		ctx := &cancelAfter{Context: context.Background(), n: n}
+		if got, err = ToHTMLContext(ctx, input, nil, nil); err != context.Canceled || got != nil {
+			t.Errorf("canceled after %d checks: got %d bytes, %v", n, len(got), err)
+		}
+	}
+}
+
+func TestToHTMLSafe(t *testing.T) {
+	tests := []string{
+		"<script>alert(1)</script>\n\ntext\n",
+		"<p>text</p>\n",
+
+		"a <script>alert(1)</script> b\n",
+		"<p>a alert(1) b</p>\n",
+
+		"[x](javascript:alert(1))\n",
+		"<p><tt>x</tt>)</p>\n",
+
+		"<a href=\"javascript:alert(1)\">x</a>\n",
+		"<p><a>x</a></p>\n",
+
+		"<img src=\"x.png\" onerror=\"alert(1)\">\n",
+		"<p><img src=\"x.png\"></p>\n",
+
+		"![i](javascript:alert(1))\n",
+		"<p><img alt=\"i\" />)</p>\n",
+
+		// safe markup is kept
+		"[ok](http://x.org) ![i](img.png) <b>bold</b>\n",
+		"<p><a href=\"http://x.org\">ok</a> <img src=\"img.png\" alt=\"i\" /> <b>bold</b></p>\n",
+	}
+	for i := 0; i+1 < len(tests); i += 2 {
+		if got := string(ToHTMLSafe([]byte(tests[i]))); got != tests[i+1] {
+			t.Errorf("ToHTMLSafe(%q) = %q, want %q", tests[i], got, tests[i+1])
+		}
+	}
+}
+
+func TestMarkdownEPUB(t *testing.T) {
+	doc := `Preface.
+
+# Chapter
+
+It's *the* first[^1].
+
+## Part
+
+<div class=note onclick=x()>
+A note<br>
+</div>
+
+# Chapter
+
+The <b>second</b> -- <i>unclosed.
+
+[^1]: A footnote.
+`
+	out := MarkdownEPUB([]byte(doc), "A book")
+	if !bytes.HasPrefix(out, []byte("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<!DOCTYPE html>\n")) {
+		t.Errorf("missing XML declaration or doctype:\n%s", out)
+	}
+	for _, want := range []string{
+		`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">`,
+		"<title>A book</title>",
+		"<section epub:type=\"chapter\">\n\n<h1 id=\"chapter\">Chapter</h1>",
+		"<section epub:type=\"chapter\">\n\n<h1 id=\"chapter-1\">Chapter</h1>",
+		"<div class=\"note\">\nA note<br />\n</div>",
+		"&lt;i&gt;unclosed.</p>\n\n</section>\n\n<div class=\"footnotes\">",
+	} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if n := bytes.Count(out, []byte("<section")); n != 2 {
+		t.Errorf("got %d sections, want 2:\n%s", n, out)
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(out))
+	for {
+		_, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("output is not well-formed XML: %s\n%s", err, out)
+		}
+	}
+}
@@ -9,9 +9,19 @@ import (
 	"github.com/gomarkdown/markdown/ast"
 )
 
+// RendererOptions tweaks the output of the Renderer.
+type RendererOptions struct {
+	// WrapWidth, if > 0, is the column at which the text of paragraphs is
+	// hard-wrapped. Lines are only broken at spaces of the text, never inside
+	// code, links or HTML, and words longer than WrapWidth are kept whole.
+	WrapWidth int
+}
+
 // Renderer renders to markdown. Allows to convert to a canonnical
 // form
 type Renderer struct {
+	opts RendererOptions
+
 	orderedListCounter map[int]int
 	// used to keep track of whether a given list item uses a paragraph
 	// for large spacing.
@@ -24,7 +34,13 @@ type Renderer struct {
 
 // NewRenderer returns a Markdown renderer.
 func NewRenderer() *Renderer {
+	return NewRendererWithOptions(RendererOptions{})
+}
+
+// NewRendererWithOptions returns a Markdown renderer tweaked by opts.
+func NewRendererWithOptions(opts RendererOptions) *Renderer {
 	return &Renderer{
+		opts:               opts,
 		orderedListCounter: map[int]int{},
 		paragraph:          map[int]bool{},
 	}
@@ -105,6 +121,76 @@ func (r *Renderer) para(w io.Writer, node *ast.Paragraph, entering bool) {
 	}
 }
 
+// wrapParagraph writes the content of para hard-wrapped at WrapWidth. The text
+// nodes are split into words at spaces, soft breaks included; the output of
+// the other nodes is glued to the words around it.
+func (r *Renderer) wrapParagraph(w io.Writer, para *ast.Paragraph) {
+	var words []string
+	var word bytes.Buffer
+	endWord := func() {
+		if word.Len() > 0 {
+			words = append(words, word.String())
+			word.Reset()
+		}
+	}
+	for _, child := range para.GetChildren() {
+		ast.WalkFunc(child, func(node ast.Node, entering bool) ast.WalkStatus {
+			var text []byte
+			switch node := node.(type) {
+			case *ast.Text:
+				var buf bytes.Buffer
+				r.text(&buf, node)
+				text = buf.Bytes()
+			case *ast.Softbreak:
+				text = []byte(" ")
+			case *ast.Link, *ast.Image, *ast.Code, *ast.HTMLSpan:
+				// a single word, so that its text isn't broken across lines
+				if entering {
+					r.renderWord(&word, node)
+				}
+				return ast.SkipChildren
+			default:
+				r.RenderNode(&word, node, entering)
+				return ast.GoToNext
+			}
+			for _, c := range text {
+				if c == ' ' {
+					endWord()
+				} else {
+					word.WriteByte(c)
+				}
+			}
+			return ast.GoToNext
+		})
+	}
+	endWord()
+
+	// the paragraph starts after a space
+	col := 1
+	for i, word := range words {
+		if i > 0 && col+1+len(word) > r.opts.WrapWidth {
+			r.outs(w, "\n")
+			col = 0
+		} else if i > 0 {
+			r.outs(w, " ")
+			col++
+		}
+		r.outs(w, word)
+		col += len(word)
+	}
+}
+
+// renderWord renders node and its children to w, with soft breaks as spaces.
+func (r *Renderer) renderWord(w io.Writer, node ast.Node) {
+	ast.WalkFunc(node, func(node ast.Node, entering bool) ast.WalkStatus {
+		if _, ok := node.(*ast.Softbreak); ok {
+			r.outs(w, " ")
+			return ast.GoToNext
+		}
+		return r.RenderNode(w, node, entering)
+	})
+}
+
 // escape replaces instances of backslash with escaped backslash in text.
 func escape(text []byte) []byte {
 	return bytes.Replace(text, []byte(`\`), []byte(`\\`), -1)
@@ -211,6 +297,17 @@ func (r *Renderer) del(w io.Writer, node *ast.Del) {
 	r.outs(w, "~~")
 }
 
+// mark writes one of the == around the text, once entering and once exiting
+// node: its text is in its children.
+func (r *Renderer) mark(w io.Writer) {
+	r.outs(w, "==")
+}
+
+// ins writes one of the ++ around the text, like mark.
+func (r *Renderer) ins(w io.Writer) {
+	r.outs(w, "++")
+}
+
 func (r *Renderer) strong(w io.Writer, node *ast.Strong) {
 	text := node.Literal
 	r.outs(w, "**")
@@ -271,13 +368,14 @@ func (r *Renderer) code(w io.Writer, node *ast.Code) {
 	r.outs(w, "`")
 }
 
-func (r *Renderer) image(w io.Writer, node *ast.Image) {
+func (r *Renderer) image(w io.Writer, node *ast.Image, entering bool) {
+	// the alt text is in the children
+	if entering {
+		r.outs(w, "![")
+		return
+	}
 	link := node.Destination
 	title := node.Title
-	// alt := node. ??
-	var alt []byte
-	r.outs(w, "![")
-	r.out(w, alt)
 	r.outs(w, "](")
 	r.out(w, escape(link))
 	if len(title) != 0 {
@@ -288,12 +386,15 @@ func (r *Renderer) image(w io.Writer, node *ast.Image) {
 	r.outs(w, ")")
 }
 
-func (r *Renderer) link(w io.Writer, node *ast.Link) {
+func (r *Renderer) link(w io.Writer, node *ast.Link, entering bool) {
+	// the link text is in the children
+	if entering {
+		r.outs(w, "[")
+		r.out(w, node.Literal)
+		return
+	}
 	link := string(escape(node.Destination))
 	title := string(node.Title)
-	content := string(node.Literal)
-	r.outs(w, "[")
-	r.outs(w, content)
 	r.outs(w, "](")
 	r.outs(w, link)
 	if len(title) != 0 {
@@ -310,7 +411,7 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 	case *ast.Text:
 		r.text(w, node)
 	case *ast.Softbreak:
-		panic(fmt.Sprintf("node %T NYI", node))
+		r.outs(w, "\n")
 	case *ast.Hardbreak:
 		panic(fmt.Sprintf("node %T NYI", node))
 	case *ast.Emph:
@@ -319,19 +420,22 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 		r.strong(w, node)
 	case *ast.Del:
 		r.del(w, node)
+	case *ast.Mark:
+		r.mark(w)
+	case *ast.Ins:
+		r.ins(w)
 	case *ast.BlockQuote:
 		panic(fmt.Sprintf("node %T NYI", node))
 	case *ast.Aside:
 		panic(fmt.Sprintf("node %T NYI", node))
 	case *ast.Link:
-		r.link(w, node)
+		r.link(w, node, entering)
 	case *ast.CrossReference:
 		panic(fmt.Sprintf("node %T NYI", node))
 	case *ast.Citation:
 		panic(fmt.Sprintf("node %T NYI", node))
 	case *ast.Image:
-		r.image(w, node)
-		panic(fmt.Sprintf("node %T NYI", node))
+		r.image(w, node, entering)
 	case *ast.Code:
 		r.code(w, node)
 	case *ast.CodeBlock:
@@ -344,6 +448,10 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 		// do nothing
 	case *ast.Paragraph:
 		r.para(w, node, entering)
+		if entering && r.opts.WrapWidth > 0 {
+			r.wrapParagraph(w, node)
+			return ast.SkipChildren
+		}
 	case *ast.HTMLSpan:
 		r.htmlSpan(w, node)
 	case *ast.HTMLBlock:
@@ -382,6 +490,12 @@ func (r *Renderer) RenderNode(w io.Writer, node ast.Node, entering bool) ast.Wal
 		panic(fmt.Sprintf("node %T NYI", node))
 	case *ast.Superscript:
 		panic(fmt.Sprintf("node %T NYI", node))
+	case *ast.Abbreviation:
+		r.out(w, node.Literal)
+	case *ast.Kbd:
+		r.outs(w, "[[[")
+		r.out(w, node.Literal)
+		r.outs(w, "]]]")
 	case *ast.Footnotes:
 		// nothing by default; just output the list.
 	default:
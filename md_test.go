@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/gomarkdown/markdown/ast"
@@ -54,3 +55,38 @@ func TestMd(t *testing.T) {
 		}
 	}
 }
+
+func TestMdWrapWidth(t *testing.T) {
+	long := strings.Repeat("x", 90)
+	input := "Wrapping breaks lines at the spaces of the text only, so `code spans stay whole`\nand long words like " + long + " are kept on a line of their own.\n"
+	exp := " Wrapping breaks lines at the spaces of the text only, so\n`code spans stay whole` and long words like\n" + long + "\nare kept on a line of their own.\n"
+
+	doc := Parse([]byte(input), parser.New())
+	got := string(Render(doc, md.NewRendererWithOptions(md.RendererOptions{WrapWidth: 80})))
+	if got != exp {
+		t.Errorf("got\n%s\nwant\n%s", got, exp)
+	}
+	for _, line := range strings.Split(got, "\n") {
+		if len(line) > 80 && line != long {
+			t.Errorf("line longer than 80 columns: %q", line)
+		}
+	}
+
+	// no wrapping by default
+	got = string(Render(doc, md.NewRenderer()))
+	if exp := " " + input; got != exp {
+		t.Errorf("got %q, want %q", got, exp)
+	}
+}
+
+func TestMdWrapWidthLinks(t *testing.T) {
+	// links and images are kept whole, even when longer than the width
+	input := "See [the long text\nof a link](/url \"a title\") and ![an image](/i.png) here.\n"
+	exp := " See\n[the long text of a link](/url \"a title\")\nand\n![an image](/i.png)\nhere.\n"
+
+	doc := Parse([]byte(input), parser.New())
+	got := string(Render(doc, md.NewRendererWithOptions(md.RendererOptions{WrapWidth: 10})))
+	if got != exp {
+		t.Errorf("got\n%s\nwant\n%s", got, exp)
+	}
+}
@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// isAbbreviation parses an abbreviation definition on the first line of
+// data:
+//
+//	*[HTML]: Hyper Text Markup Language
+//
+// If found, the abbreviation is recorded and the length of the line is
+// returned, otherwise 0.
+func (p *Parser) isAbbreviation(data []byte) int {
+	i := 0
+	for i < 3 && i < len(data) && data[i] == ' ' {
+		i++
+	}
+	if !bytes.HasPrefix(data[i:], []byte("*[")) {
+		return 0
+	}
+	i += 2
+	start := i
+	for i < len(data) && data[i] != ']' && data[i] != '\n' {
+		i++
+	}
+	if i+1 >= len(data) || data[i] != ']' || data[i+1] != ':' {
+		return 0
+	}
+	term := bytes.TrimSpace(data[start:i])
+	if len(term) == 0 {
+		return 0
+	}
+	i += 2
+	end := i
+	for end < len(data) && data[end] != '\n' {
+		end++
+	}
+	title := bytes.TrimSpace(data[i:end])
+	if end < len(data) {
+		end++
+	}
+
+	if p.abbrs == nil {
+		p.abbrs = make(map[string][]byte)
+	}
+	p.abbrs[string(term)] = title
+	return end
+}
+
+// markAbbreviations replaces the defined abbreviations in all text nodes of
+// the document with Abbreviation nodes. Only whole words are replaced.
+func (p *Parser) markAbbreviations() {
+	terms := make([][]byte, 0, len(p.abbrs))
+	for term := range p.abbrs {
+		terms = append(terms, []byte(term))
+	}
+	// try longer terms first, so that e.g. HTML5 wins over HTML
+	sort.Slice(terms, func(i, j int) bool {
+		if len(terms[i]) != len(terms[j]) {
+			return len(terms[i]) > len(terms[j])
+		}
+		return bytes.Compare(terms[i], terms[j]) < 0
+	})
+
+	var texts []*ast.Text
+	ast.WalkFunc(p.Doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if text, ok := node.(*ast.Text); ok && entering {
+			texts = append(texts, text)
+		}
+		return ast.GoToNext
+	})
+	for _, text := range texts {
+		p.splitAbbreviations(text, terms)
+	}
+}
+
+// splitAbbreviations replaces text with text and Abbreviation nodes if it
+// contains any of terms.
+func (p *Parser) splitAbbreviations(text *ast.Text, terms [][]byte) {
+	data := text.Literal
+	var nodes []ast.Node
+	beg := 0
+	for i := 0; i < len(data); i++ {
+		if i > 0 && isWordBefore(data, i) {
+			continue
+		}
+		for _, term := range terms {
+			end := i + len(term)
+			if !bytes.HasPrefix(data[i:], term) || end < len(data) && isWordAt(data, end) {
+				continue
+			}
+			if beg < i {
+				nodes = append(nodes, newTextNode(data[beg:i]))
+			}
+			abbr := &ast.Abbreviation{Title: p.abbrs[string(term)]}
+			abbr.Literal = data[i:end]
+			nodes = append(nodes, abbr)
+			beg = end
+			i = end - 1
+			break
+		}
+	}
+	if len(nodes) == 0 {
+		return
+	}
+	if beg < len(data) {
+		nodes = append(nodes, newTextNode(data[beg:]))
+	}
+
+	parent := text.Parent
+	children := parent.GetChildren()
+	for i, child := range children {
+		if child != ast.Node(text) {
+			continue
+		}
+		newChildren := make([]ast.Node, 0, len(children)+len(nodes)-1)
+		newChildren = append(newChildren, children[:i]...)
+		newChildren = append(newChildren, nodes...)
+		newChildren = append(newChildren, children[i+1:]...)
+		for _, node := range nodes {
+			node.SetParent(parent)
+		}
+		parent.SetChildren(newChildren)
+		return
+	}
+}
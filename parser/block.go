@@ -53,16 +53,21 @@ var (
 		"article":    struct{}{},
 		"aside":      struct{}{},
 		"canvas":     struct{}{},
+		"details":    struct{}{},
+		"dialog":     struct{}{},
 		"figcaption": struct{}{},
 		"figure":     struct{}{},
 		"footer":     struct{}{},
 		"header":     struct{}{},
 		"hgroup":     struct{}{},
 		"main":       struct{}{},
+		"menu":       struct{}{},
 		"nav":        struct{}{},
 		"output":     struct{}{},
 		"progress":   struct{}{},
+		"search":     struct{}{},
 		"section":    struct{}{},
+		"summary":    struct{}{},
 		"video":      struct{}{},
 	}
 )
@@ -97,13 +102,25 @@ func (p *Parser) block(data []byte) {
 	}
 	p.nesting++
 
+	// for EmptyLineBeforeTable
+	start := data
+
 	// parse out one block-level construct at a time
-	for len(data) > 0 {
+	for len(data) > 0 && !p.canceled() {
+		if p.nesting == 1 && p.input != nil {
+			p.setSourceOffsets()
+			p.setBlockOffset(len(p.input) - len(data))
+		}
+		afterEmptyLine := isAfterEmptyLine(start[:len(start)-len(data)])
+
 		// attributes that can be specific before a block element:
 		//
 		// {#id .class1 .class2 key="value"}
 		if p.extensions&Attributes != 0 {
 			data = p.attribute(data)
+			if len(data) == 0 {
+				break
+			}
 		}
 
 		if p.extensions&Includes != 0 {
@@ -123,21 +140,10 @@ func (p *Parser) block(data []byte) {
 			}
 		}
 
-		// user supplied parser function
-		if p.Opts.ParserHook != nil {
-			node, blockdata, consumed := p.Opts.ParserHook(data)
-			if consumed > 0 {
-				data = data[consumed:]
-
-				if node != nil {
-					p.addBlock(node)
-					if blockdata != nil {
-						p.block(blockdata)
-						p.finalize(node)
-					}
-				}
-				continue
-			}
+		// user supplied parser functions
+		if consumed := p.userBlock(data); consumed > 0 {
+			data = data[consumed:]
+			continue
 		}
 
 		// prefixed heading:
@@ -216,7 +222,7 @@ func (p *Parser) block(data []byte) {
 		// }
 		// ```
 		if p.extensions&FencedCode != 0 {
-			if i := p.fencedCodeBlock(data, true); i > 0 {
+			if i := p.fencedCodeBlock(data, true, true); i > 0 {
 				data = data[i:]
 				continue
 			}
@@ -275,7 +281,7 @@ func (p *Parser) block(data []byte) {
 		// ------|-----|---------
 		// Bob   | 31  | 555-1234
 		// Alice | 27  | 555-4321
-		if p.extensions&Tables != 0 {
+		if p.extensions&Tables != 0 && (afterEmptyLine || p.extensions&EmptyLineBeforeTable == 0) {
 			if i := p.table(data); i > 0 {
 				data = data[i:]
 				continue
@@ -345,12 +351,54 @@ func (p *Parser) block(data []byte) {
 		// anything else must look like a normal paragraph
 		// note: this finds underlined headings, too
 		idx := p.paragraph(data)
+		if idx == 0 {
+			// paragraph always consumes its first line, but never loop
+			// forever if it doesn't
+			end := skipUntilChar(data, 0, '\n')
+			p.renderParagraph(data[:end])
+			idx = skipCharN(data, end, '\n', 1)
+		}
 		data = data[idx:]
 	}
 
+	if p.nesting == 1 && p.input != nil {
+		p.setSourceOffsets()
+	}
 	p.nesting--
 }
 
+// userBlock tries Options.ParserHook and then the functions registered with
+// RegisterBlock, and returns the number of bytes consumed by the first one
+// that handles data.
+func (p *Parser) userBlock(data []byte) int {
+	if p.Opts.ParserHook != nil {
+		if consumed := p.addUserBlock(p.Opts.ParserHook(data)); consumed > 0 {
+			return consumed
+		}
+	}
+	for _, h := range p.blockHandlers {
+		if !bytes.HasPrefix(data, h.prefix) {
+			continue
+		}
+		if consumed := p.addUserBlock(h.fn(data)); consumed > 0 {
+			return consumed
+		}
+	}
+	return 0
+}
+
+// addUserBlock adds the result of a BlockFunc to the document.
+func (p *Parser) addUserBlock(node ast.Node, blockdata []byte, consumed int) int {
+	if consumed > 0 && node != nil {
+		p.addBlock(node)
+		if blockdata != nil {
+			p.block(blockdata)
+			p.finalize(node)
+		}
+	}
+	return consumed
+}
+
 func (p *Parser) addBlock(n ast.Node) ast.Node {
 	p.closeUnmatchedBlocks()
 
@@ -556,6 +604,12 @@ func (p *Parser) html(data []byte, doRender bool) int {
 	if data[0] != '<' {
 		return 0
 	}
+
+	// <pre>, <script> etc. hold text that is not markdown, even blank lines
+	if size := p.htmlRawTextBlock(data, doRender); size > 0 {
+		return size
+	}
+
 	curtag, tagfound := p.htmlFindTag(data[1:])
 
 	// handle special cases
@@ -570,6 +624,11 @@ func (p *Parser) html(data []byte, doRender bool) int {
 			return size
 		}
 
+		// check for a CDATA section, a processing instruction or a declaration
+		if size := p.htmlSpecialBlock(data, doRender); size > 0 {
+			return size
+		}
+
 		// no special case recognized
 		return 0
 	}
@@ -608,11 +667,13 @@ func (p *Parser) html(data []byte, doRender bool) int {
 
 	// if not found, try a second pass looking for indented match
 	// but not if tag is "ins" or "del" (following original Markdown.pl)
+	// nested tags with the same name are skipped by tracking their depth
 	if !found && curtag != "ins" && curtag != "del" {
+		depth := 1
 		i = 1
 		for i < len(data) {
 			i++
-			for i < len(data) && !(data[i-1] == '<' && data[i] == '/') {
+			for i < len(data) && data[i-1] != '<' {
 				i++
 			}
 
@@ -620,6 +681,21 @@ func (p *Parser) html(data []byte, doRender bool) int {
 				break
 			}
 
+			if data[i] != '/' {
+				if isHTMLOpenTag(curtag, data[i:]) {
+					depth++
+				}
+				continue
+			}
+
+			if !bytes.HasPrefix(data[i+1:], []byte(curtag+">")) {
+				continue
+			}
+			depth--
+			if depth > 0 {
+				continue
+			}
+
 			j = p.htmlFindEnd(curtag, data[i-1:])
 
 			if j > 0 {
@@ -638,7 +714,7 @@ func (p *Parser) html(data []byte, doRender bool) int {
 	if doRender {
 		// trim newlines
 		end := backChar(data, i, '\n')
-		htmlBLock := &ast.HTMLBlock{ast.Leaf{Content: data[:end]}}
+		htmlBLock := &ast.HTMLBlock{Leaf: ast.Leaf{Content: data[:end]}}
 		p.addBlock(htmlBLock)
 		finalizeHTMLBlock(htmlBLock)
 	}
@@ -660,7 +736,7 @@ func (p *Parser) htmlComment(data []byte, doRender bool) int {
 		if doRender {
 			// trim trailing newlines
 			end := backChar(data, size, '\n')
-			htmlBLock := &ast.HTMLBlock{ast.Leaf{Content: data[:end]}}
+			htmlBLock := &ast.HTMLBlock{Leaf: ast.Leaf{Content: data[:end]}}
 			p.addBlock(htmlBLock)
 			finalizeHTMLBlock(htmlBLock)
 		}
@@ -692,7 +768,7 @@ func (p *Parser) htmlHr(data []byte, doRender bool) int {
 			if doRender {
 				// trim newlines
 				end := backChar(data, size, '\n')
-				htmlBlock := &ast.HTMLBlock{ast.Leaf{Content: data[:end]}}
+				htmlBlock := &ast.HTMLBlock{Leaf: ast.Leaf{Content: data[:end]}}
 				p.addBlock(htmlBlock)
 				finalizeHTMLBlock(htmlBlock)
 			}
@@ -702,6 +778,91 @@ func (p *Parser) htmlHr(data []byte, doRender bool) int {
 	return 0
 }
 
+// htmlSpecialBlocks are the HTML blocks, besides tags and comments, that
+// CommonMark recognizes, with the marker that ends them. CommonMark's last
+// kind of HTML block, any tag alone on its line up to a blank line, is not
+// recognized: like Markdown.pl, other tags are only blocks if they're block
+// tags closed by a tag followed by a blank line.
+var htmlSpecialBlocks = []struct {
+	open, close string
+}{
+	{"<![CDATA[", "]]>"}, // CDATA section
+	{"<?", "?>"},         // processing instruction, e.g. <?php ... ?>
+	{"<!", ">"},          // declaration, e.g. <!DOCTYPE html>
+}
+
+// CDATA section, processing instruction or declaration, which ends with the
+// line holding its closing marker
+func (p *Parser) htmlSpecialBlock(data []byte, doRender bool) int {
+	for _, b := range htmlSpecialBlocks {
+		if !bytes.HasPrefix(data, []byte(b.open)) {
+			continue
+		}
+		// a declaration starts with a letter
+		if b.open == "<!" && (len(data) < 3 || !isLetter(data[2])) {
+			return 0
+		}
+		end := bytes.Index(data[len(b.open):], []byte(b.close))
+		if end < 0 {
+			return 0
+		}
+		i := skipUntilChar(data, len(b.open)+end+len(b.close), '\n')
+		i = skipCharN(data, i, '\n', 1)
+		if doRender {
+			// trim newlines
+			end := backChar(data, i, '\n')
+			htmlBlock := &ast.HTMLBlock{Leaf: ast.Leaf{Content: data[:end]}}
+			p.addBlock(htmlBlock)
+			finalizeHTMLBlock(htmlBlock)
+		}
+		return i
+	}
+	return 0
+}
+
+// htmlRawTextTags are the tags whose content is left alone up to their closing
+// tag, even across blank lines
+var htmlRawTextTags = []string{"pre", "script", "style", "textarea"}
+
+// <pre>, <script>, <style> or <textarea> block, which ends with the line
+// holding its closing tag, or with data if it isn't closed
+func (p *Parser) htmlRawTextBlock(data []byte, doRender bool) int {
+	for _, tag := range htmlRawTextTags {
+		n := len(tag) + 1
+		if len(data) <= n || !bytes.EqualFold(data[1:n], []byte(tag)) {
+			continue
+		}
+		if c := data[n]; c != '>' && !isSpace(c) {
+			continue
+		}
+		i := len(data)
+		if end := indexFold(data[n:], "</"+tag+">"); end >= 0 {
+			i = skipUntilChar(data, n+end+len(tag)+3, '\n')
+			i = skipCharN(data, i, '\n', 1)
+		}
+		if doRender {
+			// trim newlines
+			end := backChar(data, i, '\n')
+			htmlBlock := &ast.HTMLBlock{Leaf: ast.Leaf{Content: data[:end]}}
+			p.addBlock(htmlBlock)
+			finalizeHTMLBlock(htmlBlock)
+		}
+		return i
+	}
+	return 0
+}
+
+// indexFold returns the index of the first instance of s in data, ignoring
+// ASCII case, or -1
+func indexFold(data []byte, s string) int {
+	for i := 0; i+len(s) <= len(data); i++ {
+		if bytes.EqualFold(data[i:i+len(s)], []byte(s)) {
+			return i
+		}
+	}
+	return -1
+}
+
 func (p *Parser) htmlFindTag(data []byte) (string, bool) {
 	i := skipAlnum(data, 0)
 	key := string(data[:i])
@@ -711,6 +872,33 @@ func (p *Parser) htmlFindTag(data []byte) (string, bool) {
 	return "", false
 }
 
+// isHTMLOpenTag returns true if data, without the leading '<', starts with
+// an opening tag named tag. Self-closing tags don't count.
+func isHTMLOpenTag(tag string, data []byte) bool {
+	if !bytes.HasPrefix(data, []byte(tag)) || len(data) == len(tag) {
+		return false
+	}
+	if c := data[len(tag)]; c != '>' && c != '/' && !isSpace(c) {
+		return false
+	}
+	var quote byte
+	for i := len(tag); i < len(data); i++ {
+		switch {
+		case quote != 0:
+			if data[i] == quote {
+				quote = 0
+			}
+		case data[i] == '"' || data[i] == '\'':
+			quote = data[i]
+		case data[i] == '>':
+			return data[i-1] != '/'
+		case data[i] == '<':
+			return false
+		}
+	}
+	return false
+}
+
 func (p *Parser) htmlFindEnd(tag string, data []byte) int {
 	// assume data[0] == '<' && data[1] == '/' already tested
 	if tag == "hr" {
@@ -746,15 +934,89 @@ func (p *Parser) htmlFindEnd(tag string, data []byte) int {
 	return i + skip
 }
 
+// setBlockOffset sets the offset of the top-level block being parsed and
+// counts the lines up to it. Blocks are parsed in order, so only the lines
+// since the previous block are counted.
+func (p *Parser) setBlockOffset(offset int) {
+	if offset < p.lineOffset {
+		p.blockLine, p.lineOffset = 1, 0
+	}
+	p.blockLine += bytes.Count(p.input[p.lineOffset:offset], []byte{'\n'})
+	p.blockOffset, p.lineOffset = offset, offset
+}
+
+// setSourceOffsets sets the SourceOffset and SourceLine of the top-level
+// blocks added since the last call to the offset and line of the block that
+// was being parsed, if the SourcePositions flag is set.
+func (p *Parser) setSourceOffsets() {
+	if p.Opts.Flags&SourcePositions == 0 {
+		return
+	}
+	children := p.Doc.GetChildren()
+	if p.positioned > len(children) {
+		p.positioned = len(children)
+	}
+	for _, child := range children[p.positioned:] {
+		if c := child.AsContainer(); c != nil {
+			c.SourceOffset, c.SourceLine = p.blockOffset, p.blockLine
+		} else if l := child.AsLeaf(); l != nil {
+			l.SourceOffset, l.SourceLine = p.blockOffset, p.blockLine
+		}
+	}
+	p.positioned = len(children)
+}
+
+// setUnknownSourceOffsets sets the SourceOffset and SourceLine of the nodes
+// without a known position to -1, if the SourcePositions flag is set: the
+// nodes nested in top-level blocks and the top-level blocks added after
+// parsing, like the footnotes list. Otherwise they would look like they're
+// at the start of the input.
+func (p *Parser) setUnknownSourceOffsets() {
+	if p.Opts.Flags&SourcePositions == 0 {
+		return
+	}
+	children := p.Doc.GetChildren()
+	for i, child := range children {
+		ast.WalkFunc(child, func(node ast.Node, entering bool) ast.WalkStatus {
+			if !entering || (node == child && i < p.positioned) {
+				return ast.GoToNext
+			}
+			if c := node.AsContainer(); c != nil {
+				c.SourceOffset, c.SourceLine = -1, -1
+			} else if l := node.AsLeaf(); l != nil {
+				l.SourceOffset, l.SourceLine = -1, -1
+			}
+			return ast.GoToNext
+		})
+	}
+}
+
+// isAfterEmptyLine returns true if the block following before, the part of
+// its container before it, starts the container or follows an empty line.
+// Blocks consume the empty lines after them or not, so it's checked in the
+// input.
+func isAfterEmptyLine(before []byte) bool {
+	if len(before) == 0 {
+		return true
+	}
+	if before[len(before)-1] != '\n' {
+		return false
+	}
+	line := before[:len(before)-1]
+	line = line[bytes.LastIndexByte(line, '\n')+1:]
+	return len(bytes.Trim(line, " \t\r")) == 0
+}
+
 func (*Parser) isEmpty(data []byte) int {
 	// it is okay to call isEmpty on an empty buffer
 	if len(data) == 0 {
 		return 0
 	}
 
+	// a lone \r of a \r\n line ending counts as white space
 	var i int
 	for i = 0; i < len(data) && data[i] != '\n'; i++ {
-		if data[i] != ' ' && data[i] != '\t' {
+		if data[i] != ' ' && data[i] != '\t' && data[i] != '\r' {
 			return 0
 		}
 	}
@@ -869,6 +1131,12 @@ func isFenceLine(data []byte, syntax *string, oldmarker string) (end int, marker
 				syn--
 			}
 
+			// pandoc style class: {.python}
+			if syn > 0 && data[syntaxStart] == '.' {
+				syntaxStart++
+				syn--
+			}
+
 			i++
 		} else {
 			for i < n && !isSpace(data[i]) {
@@ -893,7 +1161,9 @@ func isFenceLine(data []byte, syntax *string, oldmarker string) (end int, marker
 // fencedCodeBlock returns the end index if data contains a fenced code block at the beginning,
 // or 0 otherwise. It writes to out if doRender is true, otherwise it has no side effects.
 // If doRender is true, a final newline is mandatory to recognize the fenced code block.
-func (p *Parser) fencedCodeBlock(data []byte, doRender bool) int {
+// If unclosed is true, a code block without a closing fence runs to the end of
+// data, otherwise it isn't recognized.
+func (p *Parser) fencedCodeBlock(data []byte, doRender, unclosed bool) int {
 	var syntax string
 	beg, marker := isFenceLine(data, &syntax, "")
 	if beg == 0 || beg >= len(data) {
@@ -904,6 +1174,7 @@ func (p *Parser) fencedCodeBlock(data []byte, doRender bool) int {
 	work.WriteString(syntax)
 	work.WriteByte('\n')
 
+	closed := true
 	for {
 		// safe to assume beg < len(data)
 
@@ -919,7 +1190,18 @@ func (p *Parser) fencedCodeBlock(data []byte, doRender bool) int {
 
 		// did we reach the end of the buffer without a closing marker?
 		if end >= len(data) {
-			return 0
+			if !unclosed {
+				return 0
+			}
+			if end > len(data) {
+				end = len(data)
+			}
+			if doRender {
+				work.Write(data[beg:end])
+			}
+			beg = end
+			closed = false
+			break
 		}
 
 		// verbatim copy to the working buffer
@@ -931,7 +1213,10 @@ func (p *Parser) fencedCodeBlock(data []byte, doRender bool) int {
 
 	if doRender {
 		codeBlock := &ast.CodeBlock{
-			IsFenced: true,
+			IsFenced:    true,
+			FenceChar:   marker[0],
+			FenceLength: len(marker),
+			Unclosed:    !closed,
 		}
 		codeBlock.Content = work.Bytes() // TODO: get rid of temp buffer
 
@@ -1006,7 +1291,14 @@ func (p *Parser) table(data []byte) int {
 
 	for i < len(data) {
 		pipes, rowStart := 0, i
-		for ; i < len(data) && data[i] != '\n'; i++ {
+		for ; i < len(data); i++ {
+			if data[i] == '\n' {
+				// a backslash at the end of the line continues the row
+				// on the next line
+				if !isRowContinued(data[rowStart:i]) || i+1 >= len(data) {
+					break
+				}
+			}
 			if data[i] == '|' {
 				pipes++
 			}
@@ -1175,6 +1467,9 @@ func (p *Parser) tableHeader(data []byte) (size int, columns []ast.CellAlignFlag
 func (p *Parser) tableRow(data []byte, columns []ast.CellAlignFlags, header bool) {
 	p.addBlock(&ast.TableRow{})
 	i, col := 0, 0
+	colSpan := p.extensions&TableColSpan != 0
+	var last *ast.TableCell
+	var empty []*ast.TableCell
 
 	if data[i] == '|' && !isBackslashEscaped(data, i) {
 		i++
@@ -1188,7 +1483,7 @@ func (p *Parser) tableRow(data []byte, columns []ast.CellAlignFlags, header bool
 
 		cellStart := i
 
-		for i < n && (data[i] != '|' || isBackslashEscaped(data, i)) && data[i] != '\n' {
+		for i < n && (data[i] != '|' || isBackslashEscaped(data, i)) && (data[i] != '\n' || isBackslashEscaped(data, i)) {
 			i++
 		}
 
@@ -1205,8 +1500,26 @@ func (p *Parser) tableRow(data []byte, columns []ast.CellAlignFlags, header bool
 			IsHeader: header,
 			Align:    columns[col],
 		}
-		block.Content = data[cellStart:cellEnd]
+		block.Content = unescapeTablePipes(data[cellStart:cellEnd])
+		if colSpan && last != nil && len(block.Content) == 0 {
+			// hold empty cells back until we know they're not trailing
+			empty = append(empty, block)
+			continue
+		}
+		for _, cell := range empty {
+			p.addBlock(cell)
+		}
+		empty = nil
 		p.addBlock(block)
+		last = block
+	}
+
+	// let the last populated cell span the empty and missing columns
+	if colSpan && last != nil {
+		if span := len(empty) + len(columns) - col; span > 0 {
+			last.ColSpan = span + 1
+			return
+		}
 	}
 
 	// pad it out with empty columns to get the right number
@@ -1221,6 +1534,24 @@ func (p *Parser) tableRow(data []byte, columns []ast.CellAlignFlags, header bool
 	// silently ignore rows with too many cells
 }
 
+// isRowContinued returns true if line ends with a backslash. Like for a hard
+// line break, the backslash must come right before the newline, as the cells
+// and their inline content are split on it.
+func isRowContinued(line []byte) bool {
+	i := len(line)
+	return i > 0 && line[i-1] == '\\' && !isBackslashEscaped(line, i-1)
+}
+
+// unescapeTablePipes replaces escaped pipes with literal ones. The escape is
+// only there to keep the pipe from ending the cell, so it is removed before
+// inline parsing; this makes \| work inside code spans as well.
+func unescapeTablePipes(data []byte) []byte {
+	if !bytes.Contains(data, []byte("\\|")) {
+		return data
+	}
+	return bytes.ReplaceAll(data, []byte("\\|"), []byte("|"))
+}
+
 // tableFooter parses the (optional) table footer.
 func (p *Parser) tableFooter(data []byte) bool {
 	colCount := 1
@@ -1253,7 +1584,7 @@ func (p *Parser) quotePrefix(data []byte) int {
 		i++
 	}
 	if i < n && data[i] == '>' {
-		if i+1 < n && data[i+1] == ' ' {
+		if i+1 < n && (data[i+1] == ' ' || data[i+1] == '\t') {
 			return i + 2
 		}
 		return i + 1
@@ -1273,6 +1604,30 @@ func (p *Parser) terminateBlockquote(data []byte, beg, end int) bool {
 	return p.quotePrefix(data[end:]) == 0 && p.isEmpty(data[end:]) == 0
 }
 
+// types of GitHub alerts
+var alertTypes = []string{"note", "tip", "important", "warning", "caution"}
+
+// alertMarker returns the type of the GitHub alert marker like [!NOTE] alone
+// on the first line of data, the content of a blockquote, lowercased and the
+// length of the line. It returns "" and 0 if there is no marker.
+func alertMarker(data []byte) (string, int) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		end = len(data)
+	}
+	line := bytes.TrimRight(data[:end], " \t")
+	if !bytes.HasPrefix(line, []byte("[!")) || !bytes.HasSuffix(line, []byte("]")) {
+		return "", 0
+	}
+	typ := bytes.ToLower(line[2 : len(line)-1])
+	for _, alert := range alertTypes {
+		if string(typ) == alert {
+			return alert, skipCharN(data, end, '\n', 1)
+		}
+	}
+	return "", 0
+}
+
 // parse a blockquote fragment
 func (p *Parser) quote(data []byte) int {
 	var raw bytes.Buffer
@@ -1284,7 +1639,7 @@ func (p *Parser) quote(data []byte) int {
 		// irregardless of any contents inside it
 		for end < len(data) && data[end] != '\n' {
 			if p.extensions&FencedCode != 0 {
-				if i := p.fencedCodeBlock(data[end:], false); i > 0 {
+				if i := p.fencedCodeBlock(data[end:], false, false); i > 0 {
 					// -1 to compensate for the extra end++ after the loop:
 					end += i - 1
 					break
@@ -1304,8 +1659,16 @@ func (p *Parser) quote(data []byte) int {
 		beg = end
 	}
 
+	alert := ""
+	if p.extensions&Alerts != 0 {
+		var n int
+		if alert, n = alertMarker(raw.Bytes()); n > 0 {
+			raw.Next(n)
+		}
+	}
+
 	if p.extensions&Mmark == 0 {
-		block := p.addBlock(&ast.BlockQuote{})
+		block := p.addBlock(&ast.BlockQuote{Alert: alert})
 		p.block(raw.Bytes())
 		p.finalize(block)
 		return end
@@ -1318,7 +1681,7 @@ func (p *Parser) quote(data []byte) int {
 		p.Inline(caption, captionContent)
 
 		p.addBlock(figure) // this discard any attributes
-		block := &ast.BlockQuote{}
+		block := &ast.BlockQuote{Alert: alert}
 		block.AsContainer().Attribute = figure.AsContainer().Attribute
 		p.addChild(block)
 		p.block(raw.Bytes())
@@ -1332,7 +1695,7 @@ func (p *Parser) quote(data []byte) int {
 		return end
 	}
 
-	block := p.addBlock(&ast.BlockQuote{})
+	block := p.addBlock(&ast.BlockQuote{Alert: alert})
 	p.block(raw.Bytes())
 	p.finalize(block)
 
@@ -1341,16 +1704,31 @@ func (p *Parser) quote(data []byte) int {
 
 // returns prefix length for block code
 func (p *Parser) codePrefix(data []byte) int {
-	n := len(data)
-	if n >= 1 && data[0] == '\t' {
-		return 1
-	}
-	if n >= 4 && data[3] == ' ' && data[2] == ' ' && data[1] == ' ' && data[0] == ' ' {
-		return 4
+	if indent, n := lineIndent(data, 4); indent >= 4 {
+		return n
 	}
 	return 0
 }
 
+// lineIndent returns the width of the leading spaces and tabs of data, up to
+// at least max columns, and the number of bytes they take. A tab advances to
+// the next multiple of 4 columns, so "\t", "    " and "  \t" are all an
+// indentation of 4.
+func lineIndent(data []byte, max int) (indent int, n int) {
+	for n < len(data) && indent < max {
+		switch data[n] {
+		case ' ':
+			indent++
+		case '\t':
+			indent += 4 - indent%4
+		default:
+			return indent, n
+		}
+		n++
+	}
+	return indent, n
+}
+
 func (p *Parser) code(data []byte) int {
 	var work bytes.Buffer
 
@@ -1392,6 +1770,9 @@ func (p *Parser) code(data []byte) int {
 	codeBlock := &ast.CodeBlock{
 		IsFenced: false,
 	}
+	if p.Opts.DefaultCodeLanguage != "" {
+		codeBlock.Info = []byte(p.Opts.DefaultCodeLanguage)
+	}
 	// TODO: get rid of temp buffer
 	codeBlock.Content = work.Bytes()
 	p.addBlock(codeBlock)
@@ -1537,19 +1918,16 @@ func finalizeList(list *ast.List) {
 // Assumes initial prefix is already removed if this is a sublist.
 func (p *Parser) listItem(data []byte, flags *ast.ListType) int {
 	// keep track of the indentation of the first line
-	itemIndent := 0
-	if data[0] == '\t' {
-		itemIndent += 4
-	} else {
-		for itemIndent < 3 && data[itemIndent] == ' ' {
-			itemIndent++
-		}
-	}
+	itemIndent, _ := lineIndent(data, 4)
 
 	var bulletChar byte = '*'
+	number := 0
 	i := p.uliPrefix(data)
 	if i == 0 {
 		i = p.oliPrefix(data)
+		if i > 0 {
+			number, _ = strconv.Atoi(string(bytes.TrimLeft(data[:i-2], " ")))
+		}
 	} else {
 		bulletChar = data[i-2]
 	}
@@ -1570,7 +1948,8 @@ func (p *Parser) listItem(data []byte, flags *ast.ListType) int {
 	}
 
 	// skip leading whitespace on first line
-	i = skipChar(data, i, ' ')
+	_, skip := lineIndent(data[i:], len(data))
+	i += skip
 
 	// find the end of the line
 	line := i
@@ -1607,17 +1986,7 @@ gatherlines:
 		}
 
 		// calculate the indentation
-		indent := 0
-		indentIndex := 0
-		if data[line] == '\t' {
-			indentIndex++
-			indent += 4
-		} else {
-			for indent < 4 && line+indent < i && data[line+indent] == ' ' {
-				indent++
-				indentIndex++
-			}
-		}
+		indent, indentIndex := lineIndent(data[line:i], 4)
 
 		chunk := data[line+indentIndex : i]
 
@@ -1710,6 +2079,7 @@ gatherlines:
 		Tight:      false,
 		BulletChar: bulletChar,
 		Delimiter:  '.', // Only '.' is possible in Markdown, but ')' will also be possible in CommonMark
+		Number:     number,
 	}
 	p.addBlock(listItem)
 
@@ -1810,6 +2180,14 @@ func (p *Parser) paragraph(data []byte) int {
 			return i + refEnd
 		}
 
+		// abbreviation definitions end a paragraph like references
+		if p.extensions&Abbreviations != 0 {
+			if abbrEnd := p.isAbbreviation(current); abbrEnd > 0 {
+				p.renderParagraph(data[:i])
+				return i + abbrEnd
+			}
+		}
+
 		// did we find a blank line marking the end of the paragraph?
 		if n := p.isEmpty(current); n > 0 {
 			// did this blank line followed by a definition list item?
@@ -1873,7 +2251,7 @@ func (p *Parser) paragraph(data []byte) int {
 
 		// if there's a fenced code block, paragraph is over
 		if p.extensions&FencedCode != 0 {
-			if p.fencedCodeBlock(current, false) > 0 {
+			if p.fencedCodeBlock(current, false, true) > 0 {
 				p.renderParagraph(data[:i])
 				return i
 			}
@@ -49,8 +49,7 @@ func citation(p *Parser, data []byte, offset int) (int, ast.Node) {
 	for _, citation := range citations {
 		var suffix []byte
 		citation = bytes.TrimSpace(citation)
-		j := 0
-		if citation[j] != '@' {
+		if len(citation) == 0 || citation[0] != '@' {
 			// not a citation, drop out entirely.
 			return 0, nil
 		}
@@ -63,9 +62,13 @@ func citation(p *Parser, data []byte, offset int) (int, ast.Node) {
 			citation = part
 			suffix = suff
 		}
+		if len(citation) < 2 {
+			// just '@', there is nothing to cite.
+			return 0, nil
+		}
 
 		citeType := ast.CitationTypeInformative
-		j = 1
+		j := 1
 		switch citation[j] {
 		case '!':
 			citeType = ast.CitationTypeNormative
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// DefaultEmoji maps GitHub emoji shortcodes, without the colons, to their
+// Unicode emoji. It is used by the Emoji extension if Options.Emoji is nil.
+var DefaultEmoji = map[string]string{
+	"+1":                 "👍",
+	"-1":                 "👎",
+	"100":                "💯",
+	"angry":              "😠",
+	"apple":              "🍎",
+	"arrow_down":         "⬇️",
+	"arrow_left":         "⬅️",
+	"arrow_right":        "➡️",
+	"arrow_up":           "⬆️",
+	"baby":               "👶",
+	"beer":               "🍺",
+	"bell":               "🔔",
+	"blush":              "😊",
+	"book":               "📖",
+	"boom":               "💥",
+	"bug":                "🐛",
+	"bulb":               "💡",
+	"cake":               "🍰",
+	"calendar":           "📆",
+	"camera":             "📷",
+	"cat":                "🐱",
+	"check":              "✔️",
+	"clap":               "👏",
+	"clock":              "🕐",
+	"cloud":              "☁️",
+	"coffee":             "☕",
+	"confused":           "😕",
+	"construction":       "🚧",
+	"cool":               "🆒",
+	"cry":                "😢",
+	"dog":                "🐶",
+	"email":              "📧",
+	"exclamation":        "❗",
+	"eyes":               "👀",
+	"fire":               "🔥",
+	"flushed":            "😳",
+	"gem":                "💎",
+	"gift":               "🎁",
+	"grin":               "😁",
+	"grinning":           "😀",
+	"hammer":             "🔨",
+	"heart":              "❤️",
+	"heart_eyes":         "😍",
+	"heavy_check_mark":   "✔️",
+	"hourglass":          "⌛",
+	"house":              "🏠",
+	"hugs":               "🤗",
+	"information_source": "ℹ️",
+	"joy":                "😂",
+	"key":                "🔑",
+	"kiss":               "💋",
+	"laughing":           "😆",
+	"link":               "🔗",
+	"lock":               "🔒",
+	"mag":                "🔍",
+	"memo":               "📝",
+	"moon":               "🌙",
+	"muscle":             "💪",
+	"neutral_face":       "😐",
+	"no_entry":           "⛔",
+	"ok":                 "🆗",
+	"ok_hand":            "👌",
+	"package":            "📦",
+	"pencil2":            "✏️",
+	"point_down":         "👇",
+	"point_left":         "👈",
+	"point_right":        "👉",
+	"point_up":           "☝️",
+	"pray":               "🙏",
+	"question":           "❓",
+	"rage":               "😡",
+	"raised_hands":       "🙌",
+	"recycle":            "♻️",
+	"rocket":             "🚀",
+	"rotating_light":     "🚨",
+	"scream":             "😱",
+	"see_no_evil":        "🙈",
+	"shipit":             "🐿️",
+	"smile":              "😄",
+	"smiley":             "😃",
+	"smirk":              "😏",
+	"sob":                "😭",
+	"sparkles":           "✨",
+	"star":               "⭐",
+	"stuck_out_tongue":   "😛",
+	"sunglasses":         "😎",
+	"sunny":              "☀️",
+	"sweat_smile":        "😅",
+	"tada":               "🎉",
+	"thinking":           "🤔",
+	"thumbsdown":         "👎",
+	"thumbsup":           "👍",
+	"trophy":             "🏆",
+	"umbrella":           "☔",
+	"unlock":             "🔓",
+	"warning":            "⚠️",
+	"wave":               "👋",
+	"white_check_mark":   "✅",
+	"wink":               "😉",
+	"wrench":             "🔧",
+	"x":                  "❌",
+	"zap":                "⚡",
+	"zzz":                "💤",
+}
+
+// emoji replaces a :shortcode: with its emoji from Options.Emoji, or with an
+// image from Options.CustomEmoji. Unknown shortcodes are left alone.
+func emoji(p *Parser, data []byte, offset int) (int, ast.Node) {
+	data = data[offset:]
+
+	end := 1
+	for end < len(data) && isEmojiNameChar(data[end]) {
+		end++
+	}
+	if end == 1 || end >= len(data) || data[end] != ':' {
+		return 0, nil
+	}
+	name := string(data[1:end])
+	shortcode := data[:end+1]
+
+	if url, ok := p.Opts.CustomEmoji[name]; ok {
+		img := &ast.Image{
+			Destination: []byte(url),
+		}
+		ast.AppendChild(img, newTextNode(shortcode))
+		return end + 1, img
+	}
+
+	table := p.Opts.Emoji
+	if table == nil {
+		table = DefaultEmoji
+	}
+	if e, ok := table[name]; ok {
+		return end + 1, newTextNode([]byte(e))
+	}
+	return 0, nil
+}
+
+func isEmojiNameChar(c byte) bool {
+	return isAlnum(c) || c == '_' || c == '+' || c == '-'
+}
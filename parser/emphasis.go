@@ -0,0 +1,195 @@
+package parser
+
+import (
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// CommonMark emphasis, used with the StrictCommonMark extension.
+//
+// Instead of looking for the closing delimiter as soon as an opening one is
+// seen, runs of * and _ are added as text and remembered. Once the whole span
+// has been parsed they are paired up following the delimiter run rules in
+// https://spec.commonmark.org/0.30/#emphasis-and-strong-emphasis
+
+type delimiter struct {
+	node     *ast.Text
+	c        byte
+	count    int // delimiters left in node
+	orig     int // length of the original run
+	canOpen  bool
+	canClose bool
+
+	prev, next int        // neighbours in the delimiter stack
+	opens      []ast.Node // emphasis started by this delimiter, innermost first
+	closes     int        // number of emphasis nodes ended by this delimiter
+}
+
+// delimiterRun adds a run of * or _ as a text node and remembers it if it
+// can open or close emphasis.
+func delimiterRun(p *Parser, data []byte, offset int) (int, ast.Node) {
+	c := data[offset]
+	end := offset
+	for end < len(data) && data[end] == c {
+		end++
+	}
+
+	// the beginning and the end of the span count as white space
+	spaceBefore := offset == 0 || isSpaceBefore(data, offset)
+	spaceAfter := end == len(data) || isSpaceAt(data, end)
+	punctBefore := offset > 0 && isPunctuationBefore(data, offset)
+	punctAfter := end < len(data) && isPunctuationAt(data, end)
+	left := !spaceAfter && (!punctAfter || spaceBefore || punctBefore)
+	right := !spaceBefore && (!punctBefore || spaceAfter || punctAfter)
+
+	d := &delimiter{
+		node:  newTextNode(data[offset:end]),
+		c:     c,
+		count: end - offset,
+		orig:  end - offset,
+	}
+	if c == '_' {
+		// no intra-word emphasis with _
+		d.canOpen = left && (!right || punctBefore)
+		d.canClose = right && (!left || punctAfter)
+	} else {
+		d.canOpen, d.canClose = left, right
+	}
+	if d.canOpen || d.canClose {
+		p.delims = append(p.delims, d)
+	}
+	return end - offset, d.node
+}
+
+// processEmphasis pairs up delims, which are children of parent, and wraps
+// the nodes between matching ones in Emph and Strong nodes.
+func processEmphasis(parent ast.Node, delims []*delimiter) {
+	for i, d := range delims {
+		d.prev, d.next = i-1, i+1
+	}
+	remove := func(i int) {
+		d := delims[i]
+		if d.prev >= 0 {
+			delims[d.prev].next = d.next
+		}
+		if d.next < len(delims) {
+			delims[d.next].prev = d.prev
+		}
+	}
+
+	// lowest index to look for an opener at, by delimiter character, whether
+	// the closer can open and the closer length modulo 3, so that searches
+	// that already failed are not repeated
+	var bottom [2][2][3]int
+
+	matched := false
+	closer := 0
+	for closer < len(delims) {
+		c := delims[closer]
+		if !c.canClose {
+			closer = c.next
+			continue
+		}
+		bot := &bottom[delimIndex(c.c)][boolIndex(c.canOpen)][c.orig%3]
+
+		opener := c.prev
+		for opener >= 0 && opener >= *bot {
+			o := delims[opener]
+			if o.canOpen && o.c == c.c && !oddMatch(o, c) {
+				break
+			}
+			opener = o.prev
+		}
+		if opener < 0 || opener < *bot {
+			*bot = closer
+			next := c.next
+			if !c.canOpen {
+				remove(closer)
+			}
+			closer = next
+			continue
+		}
+
+		o := delims[opener]
+		use := 1
+		var node ast.Node = &ast.Emph{}
+		if o.count >= 2 && c.count >= 2 {
+			use = 2
+			node = &ast.Strong{}
+		}
+		o.count -= use
+		c.count -= use
+		o.opens = append(o.opens, node)
+		c.closes++
+		matched = true
+
+		// delimiters inside the new node can't be matched anymore
+		o.next, c.prev = closer, opener
+		if o.count == 0 {
+			remove(opener)
+		}
+		if c.count == 0 {
+			next := c.next
+			remove(closer)
+			closer = next
+		}
+	}
+	if matched {
+		wrapEmphasis(parent, delims)
+	}
+}
+
+// oddMatch returns true if o and c can't be paired because of the rule of 3:
+// if either can both open and close, the sum of the lengths of their runs
+// must not be a multiple of 3, unless both lengths are.
+func oddMatch(o, c *delimiter) bool {
+	return (o.canClose || c.canOpen) && (o.orig+c.orig)%3 == 0 && !(o.orig%3 == 0 && c.orig%3 == 0)
+}
+
+// wrapEmphasis rebuilds the children of parent, moving the nodes between
+// matched delimiters into the emphasis nodes they opened. Delimiters used
+// up by the matches are dropped.
+func wrapEmphasis(parent ast.Node, delims []*delimiter) {
+	children := parent.GetChildren()
+	parent.SetChildren(nil)
+	stack := []ast.Node{parent}
+	appendChild := func(child ast.Node) {
+		top := stack[len(stack)-1]
+		child.SetParent(top)
+		top.SetChildren(append(top.GetChildren(), child))
+	}
+
+	i := 0
+	for _, child := range children {
+		if i >= len(delims) || child != delims[i].node {
+			appendChild(child)
+			continue
+		}
+		d := delims[i]
+		i++
+		for n := 0; n < d.closes && len(stack) > 1; n++ {
+			stack = stack[:len(stack)-1]
+		}
+		if d.count > 0 {
+			d.node.Literal = d.node.Literal[:d.count]
+			appendChild(d.node)
+		}
+		for n := len(d.opens) - 1; n >= 0; n-- {
+			appendChild(d.opens[n])
+			stack = append(stack, d.opens[n])
+		}
+	}
+}
+
+func delimIndex(c byte) int {
+	if c == '_' {
+		return 1
+	}
+	return 0
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -2,8 +2,11 @@ package parser
 
 import (
 	"bytes"
+	"html"
 	"regexp"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gomarkdown/markdown/ast"
 )
@@ -27,10 +30,14 @@ func (p *Parser) Inline(currBlock ast.Node, data []byte) {
 	}
 	p.nesting++
 	beg, end := 0, 0
+	delims := len(p.delims)
 
 	n := len(data)
-	for end < n {
+	for end < n && !p.step(1) {
 		handler := p.inlineCallback[data[end]]
+		if handler == nil {
+			handler = inlineParser(p.InlineFallback)
+		}
 		if handler == nil {
 			end++
 			continue
@@ -50,17 +57,44 @@ func (p *Parser) Inline(currBlock ast.Node, data []byte) {
 		end = beg
 	}
 
-	if beg < n {
+	// end is before n if parsing was stopped by Options.MaxSteps
+	if beg < end {
 		if data[end-1] == '\n' {
 			end--
 		}
 		ast.AppendChild(currBlock, newTextNode(data[beg:end]))
 	}
+	if len(p.delims) > delims {
+		processEmphasis(currBlock, p.delims[delims:])
+		p.delims = p.delims[:delims]
+	}
 	p.nesting--
 }
 
 // single and double emphasis parsing
 func emphasis(p *Parser, data []byte, offset int) (int, ast.Node) {
+	if p.extensions&StrictCommonMark != 0 && (data[offset] == '*' || data[offset] == '_') {
+		return delimiterRun(p, data, offset)
+	}
+	if data[offset] == '_' && p.extensions&NoIntraUnderscore != 0 {
+		start := offset
+		for start > 0 && data[start-1] == '_' {
+			start--
+		}
+		if start > 0 && isWordBefore(data, start) {
+			return 0, nil
+		}
+	}
+	if p.extensions&NoIntraStrikethrough != 0 && data[offset] == '~' && offset > 0 && isWordBefore(data, offset) {
+		// keep the whole run as text, so that its next ~ doesn't open one
+		end := offset
+		for end < len(data) && data[end] == '~' {
+			end++
+		}
+		if end-offset >= 2 {
+			return end - offset, newTextNode(data[offset:end])
+		}
+	}
 	data = data[offset:]
 	c := data[0]
 
@@ -68,7 +102,8 @@ func emphasis(p *Parser, data []byte, offset int) (int, ast.Node) {
 	if n > 2 && data[1] != c {
 		// whitespace cannot follow an opening emphasis;
 		// strikethrough only takes two characters '~~'
-		if isSpace(data[1]) {
+		// highlight and insert only take two characters '==' and '++'
+		if c == '=' || c == '+' || isSpaceAt(data, 1) {
 			return 0, nil
 		}
 		if p.extensions&SuperSubscript != 0 && c == '~' {
@@ -97,7 +132,11 @@ func emphasis(p *Parser, data []byte, offset int) (int, ast.Node) {
 	}
 
 	if n > 3 && data[1] == c && data[2] != c {
-		if isSpace(data[2]) {
+		if c == '~' && p.extensions&Strikethrough == 0 {
+			// only here for subscripts, don't let a '~~' start one
+			return 2, newTextNode(data[:2])
+		}
+		if isSpaceAt(data, 2) {
 			return 0, nil
 		}
 		ret, node := helperDoubleEmphasis(p, data[2:], c)
@@ -109,7 +148,7 @@ func emphasis(p *Parser, data []byte, offset int) (int, ast.Node) {
 	}
 
 	if n > 4 && data[1] == c && data[2] == c && data[3] != c {
-		if c == '~' || isSpace(data[3]) {
+		if c == '~' || c == '=' || c == '+' || isSpaceAt(data, 3) {
 			return 0, nil
 		}
 		ret, node := helperTripleEmphasis(p, data, 3, c)
@@ -129,29 +168,19 @@ func codeSpan(p *Parser, data []byte, offset int) (int, ast.Node) {
 	// count the number of backticks in the delimiter
 	nb := skipChar(data, 0, '`')
 
-	// find the next delimiter
-	i, end := 0, 0
-	for end = nb; end < len(data) && i < nb; end++ {
-		if data[end] == '`' {
-			i++
-		} else {
-			i = 0
-		}
-	}
-
+	end := codeSpanEnd(data)
 	// no matching delimiter?
-	if i < nb && end >= len(data) {
+	if end == 0 {
 		return 0, nil
 	}
 
-	// trim outside whitespace
-	fBegin := nb
-	for fBegin < end && data[fBegin] == ' ' {
+	// strip one space on each side if there is one on both sides, like
+	// CommonMark, so that `` ` `` is a backtick. Spaces only are kept.
+	fBegin, fEnd := nb, end-nb
+	if p.Opts.Flags&KeepCodeSpanSpaces == 0 && fEnd-fBegin >= 2 &&
+		isCodeSpanSpace(data[fBegin]) && isCodeSpanSpace(data[fEnd-1]) &&
+		len(bytes.Trim(data[fBegin:fEnd], " \n")) > 0 {
 		fBegin++
-	}
-
-	fEnd := end - nb
-	for fEnd > fBegin && data[fEnd-1] == ' ' {
 		fEnd--
 	}
 
@@ -165,13 +194,42 @@ func codeSpan(p *Parser, data []byte, offset int) (int, ast.Node) {
 	return end, nil
 }
 
+func isCodeSpanSpace(c byte) bool {
+	return c == ' ' || c == '\n'
+}
+
+// codeSpanEnd returns the offset just past the closing delimiter of the code
+// span that starts at data[0], or 0 if the span is not closed.
+func codeSpanEnd(data []byte) int {
+	// count the number of backticks in the delimiter
+	nb := skipChar(data, 0, '`')
+
+	// find the next delimiter
+	i, end := 0, 0
+	for end = nb; end < len(data) && i < nb; end++ {
+		if data[end] == '`' {
+			i++
+		} else {
+			i = 0
+		}
+	}
+	if i < nb {
+		return 0
+	}
+	return end
+}
+
 // newline preceded by two spaces becomes <br>
 func maybeLineBreak(p *Parser, data []byte, offset int) (int, ast.Node) {
 	origOffset := offset
 	offset = skipChar(data, offset, ' ')
 
 	if offset < len(data) && data[offset] == '\n' {
-		if offset-origOffset >= 2 {
+		// spaces at the end of a block don't make a break
+		if offset == len(data)-1 {
+			return offset - origOffset, nil
+		}
+		if offset-origOffset >= 2 && p.extensions&NoTrailingSpaceBreak == 0 {
 			return offset - origOffset + 1, &ast.Hardbreak{}
 		}
 		return offset - origOffset, nil
@@ -179,12 +237,18 @@ func maybeLineBreak(p *Parser, data []byte, offset int) (int, ast.Node) {
 	return 0, nil
 }
 
-// newline without two spaces works when HardLineBreak is enabled
+// newline without two spaces works when HardLineBreak is enabled,
+// otherwise it's a soft break and renderers decide how to output it
 func lineBreak(p *Parser, data []byte, offset int) (int, ast.Node) {
+	// the final newline of a block (e.g. a list item or a quoted paragraph)
+	// terminates the text, it doesn't break it
+	if offset == len(data)-1 {
+		return 0, nil
+	}
 	if p.extensions&HardLineBreak != 0 {
 		return 1, &ast.Hardbreak{}
 	}
-	return 0, nil
+	return 1, &ast.Softbreak{}
 }
 
 type linkType int
@@ -241,6 +305,20 @@ func link(p *Parser, data []byte, offset int) (int, ast.Node) {
 		return 0, nil
 	}
 
+	// [[[Ctrl]]] == keyboard key
+	if p.extensions&Keyboard != 0 && data[offset] == '[' {
+		if n, node := keyboardKey(data, offset); n > 0 {
+			return n, node
+		}
+	}
+
+	// [[Page]] == wiki link
+	if p.extensions&WikiLinks != 0 && data[offset] == '[' && len(data)-1 > offset && data[offset+1] == '[' {
+		if n, node := wikiLink(p, data, offset); n > 0 {
+			return n, node
+		}
+	}
+
 	var t linkType
 	switch {
 	// special case: ![^text] == deferred footnote (that follows something with
@@ -448,6 +526,9 @@ func link(p *Parser, data []byte, offset int) (int, ast.Node) {
 
 		// find the reference with matching id
 		lr, ok := p.getRef(string(id))
+		if !ok {
+			lr, ok = p.getMissingRef(id)
+		}
 		if !ok {
 			return 0, nil
 		}
@@ -520,6 +601,9 @@ func link(p *Parser, data []byte, offset int) (int, ast.Node) {
 		} else {
 			// find the reference with matching id
 			lr, ok := p.getRef(string(id))
+			if !ok && t != linkDeferredFootnote {
+				lr, ok = p.getMissingRef(id)
+			}
 			if !ok {
 				return 0, nil
 			}
@@ -542,6 +626,11 @@ func link(p *Parser, data []byte, offset int) (int, ast.Node) {
 		i = txtE + 1
 	}
 
+	var width, height int
+	if t == linkImg && p.extensions&ImageSize != 0 {
+		link, width, height = imageSize(link)
+	}
+
 	var uLink []byte
 	if t == linkNormal || t == linkImg {
 		if len(link) > 0 {
@@ -580,6 +669,8 @@ func link(p *Parser, data []byte, offset int) (int, ast.Node) {
 		image := &ast.Image{
 			Destination: uLink,
 			Title:       title,
+			Width:       width,
+			Height:      height,
 		}
 		ast.AppendChild(image, newTextNode(data[1:txtE]))
 		return i + 1, image
@@ -691,11 +782,12 @@ var escapeChars = []byte("\\`*_{}[]()#+-.!:|&<>~")
 func escape(p *Parser, data []byte, offset int) (int, ast.Node) {
 	data = data[offset:]
 
+	// a backslash at the end of a block is literal
 	if len(data) <= 1 {
-		return 2, nil
+		return 0, nil
 	}
 
-	if p.extensions&BackslashLineBreak != 0 && data[1] == '\n' {
+	if (p.extensions&BackslashLineBreak != 0 || p.insideCell) && data[1] == '\n' && len(data) > 2 {
 		return 2, &ast.Hardbreak{}
 	}
 
@@ -746,11 +838,84 @@ func entity(p *Parser, data []byte, offset int) (int, ast.Node) {
 	// escaper in the renderer
 	if bytes.Equal(ent, []byte("&amp;")) {
 		ent = []byte{'&'}
+	} else if p.extensions&ValidateEntities != 0 {
+		ent = decodeEntity(ent)
 	}
 
 	return end, newTextNode(ent)
 }
 
+// decodeEntity returns the text an entity stands for. Numeric entities are
+// decoded to their code point, with invalid code points replaced by U+FFFD.
+// Named entities are looked up in the HTML5 entity table. Unknown entities
+// are returned unchanged, the renderer then escapes them as &amp;...
+func decodeEntity(ent []byte) []byte {
+	if len(ent) < 3 {
+		return ent
+	}
+	name := string(ent[1 : len(ent)-1])
+	if name[0] == '#' {
+		var cp uint64
+		var err error
+		if len(name) > 1 && (name[1] == 'x' || name[1] == 'X') {
+			cp, err = strconv.ParseUint(name[2:], 16, 32)
+		} else {
+			cp, err = strconv.ParseUint(name[1:], 10, 32)
+		}
+		if err != nil {
+			return ent
+		}
+		r := rune(cp)
+		if cp == 0 || !utf8.ValidRune(r) {
+			r = utf8.RuneError
+		}
+		return []byte(string(r))
+	}
+	s := html.UnescapeString(string(ent))
+	// UnescapeString also accepts a known prefix without the semicolon,
+	// e.g. "&copyx;" would give "©x;", so the semicolon must be consumed
+	if s == string(ent) || (strings.HasSuffix(s, ";") && name != "semi") {
+		return ent
+	}
+	return []byte(s)
+}
+
+// imageSize splits a size hint like " =100x200" off the end of the
+// destination of an image. Either dimension can be left out, as in =100x
+// or =x200.
+func imageSize(link []byte) ([]byte, int, int) {
+	i := bytes.LastIndex(link, []byte(" ="))
+	if i < 0 {
+		return link, 0, 0
+	}
+	size := bytes.SplitN(link[i+2:], []byte("x"), 2)
+	if len(size) != 2 {
+		return link, 0, 0
+	}
+	width, ok := parseImageDimension(size[0])
+	if !ok {
+		return link, 0, 0
+	}
+	height, ok := parseImageDimension(size[1])
+	if !ok || width == 0 && height == 0 {
+		return link, 0, 0
+	}
+	return bytes.TrimRight(link[:i], " \t\n"), width, height
+}
+
+func parseImageDimension(d []byte) (int, bool) {
+	if len(d) == 0 {
+		return 0, true
+	}
+	for _, c := range d {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	n, err := strconv.Atoi(string(d))
+	return n, err == nil
+}
+
 func linkEndsWithEntity(data []byte, linkEnd int) bool {
 	entityRanges := htmlEntityRe.FindAllIndex(data[:linkEnd], -1)
 	return entityRanges != nil && entityRanges[len(entityRanges)-1][1] == linkEnd
@@ -1038,8 +1203,10 @@ func tagLength(data []byte) (autolink autolinkType, end int) {
 // this is less strict than the original markdown e-mail address matching
 func isMailtoAutoLink(data []byte) int {
 	nb := 0
+	dot := false
 
-	// address is assumed to be: [-@._a-zA-Z0-9]+ with exactly one '@'
+	// address is assumed to be: [-@._a-zA-Z0-9]+ with exactly one '@' and a
+	// '.' somewhere after it
 	for i, c := range data {
 		if isAlnum(c) {
 			continue
@@ -1049,11 +1216,14 @@ func isMailtoAutoLink(data []byte) int {
 		case '@':
 			nb++
 
-		case '-', '.', '_':
+		case '.':
+			dot = dot || nb > 0
+
+		case '-', '_':
 			break
 
 		case '>':
-			if nb == 1 {
+			if nb == 1 && dot {
 				return i + 1
 			}
 			return 0
@@ -1086,19 +1256,13 @@ func helperFindEmphChar(data []byte, c byte) int {
 		}
 
 		if data[i] == '`' {
-			// skip a code span
-			tmpI := 0
-			i++
-			for i < len(data) && data[i] != '`' {
-				if tmpI == 0 && data[i] == c {
-					tmpI = i
-				}
-				i++
-			}
-			if i >= len(data) {
-				return tmpI
+			// skip a code span, delimited the same way codeSpan does it,
+			// or only its backticks if it is not closed
+			if end := codeSpanEnd(data[i:]); end > 0 {
+				i += end
+			} else {
+				i = skipChar(data, i, '`')
 			}
-			i++
 		} else if data[i] == '[' {
 			// skip a link
 			tmpI := 0
@@ -1139,6 +1303,20 @@ func helperFindEmphChar(data []byte, c byte) int {
 	return 0
 }
 
+// findEmphChar is helperFindEmphChar counting the bytes it scans as steps,
+// for Options.MaxSteps. It returns 0 once the budget is exceeded.
+func (p *Parser) findEmphChar(data []byte, c byte) int {
+	length := helperFindEmphChar(data, c)
+	scanned := length
+	if length == 0 {
+		scanned = len(data)
+	}
+	if p.step(scanned) {
+		return 0
+	}
+	return length
+}
+
 func helperEmphasis(p *Parser, data []byte, c byte) (int, ast.Node) {
 	i := 0
 
@@ -1148,7 +1326,7 @@ func helperEmphasis(p *Parser, data []byte, c byte) (int, ast.Node) {
 	}
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := p.findEmphChar(data[i:], c)
 		if length == 0 {
 			return 0, nil
 		}
@@ -1162,10 +1340,14 @@ func helperEmphasis(p *Parser, data []byte, c byte) (int, ast.Node) {
 			continue
 		}
 
-		if data[i] == c && !isSpace(data[i-1]) {
+		if data[i] == c && !isSpaceBefore(data, i) {
+			if underscoreInWord(p, data, i, c) {
+				i++
+				continue
+			}
 
 			if p.extensions&NoIntraEmphasis != 0 {
-				if !(i+1 == len(data) || isSpace(data[i+1]) || isPunctuation(data[i+1])) {
+				if !(i+1 == len(data) || isSpaceAt(data, i+1) || isPunctuationAt(data, i+1)) {
 					continue
 				}
 			}
@@ -1183,16 +1365,27 @@ func helperDoubleEmphasis(p *Parser, data []byte, c byte) (int, ast.Node) {
 	i := 0
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := p.findEmphChar(data[i:], c)
 		if length == 0 {
 			return 0, nil
 		}
 		i += length
 
-		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isSpace(data[i-1]) {
+		if i+1 < len(data) && data[i] == c && data[i+1] == c && i > 0 && !isSpaceBefore(data, i) {
+			if underscoreInWord(p, data, i, c) || strikethroughInWord(p, data, i, c) {
+				for i < len(data) && data[i] == c {
+					i++
+				}
+				continue
+			}
 			var node ast.Node = &ast.Strong{}
-			if c == '~' {
+			switch c {
+			case '~':
 				node = &ast.Del{}
+			case '=':
+				node = &ast.Mark{}
+			case '+':
+				node = &ast.Ins{}
 			}
 			p.Inline(node, data[:i])
 			return i + 2, node
@@ -1208,14 +1401,23 @@ func helperTripleEmphasis(p *Parser, data []byte, offset int, c byte) (int, ast.
 	data = data[offset:]
 
 	for i < len(data) {
-		length := helperFindEmphChar(data[i:], c)
+		length := p.findEmphChar(data[i:], c)
 		if length == 0 {
 			return 0, nil
 		}
 		i += length
+		if i >= len(data) {
+			return 0, nil
+		}
 
 		// skip whitespace preceded symbols
-		if data[i] != c || isSpace(data[i-1]) {
+		if data[i] != c || isSpaceBefore(data, i) {
+			continue
+		}
+		if underscoreInWord(p, data, i, c) {
+			for i < len(data) && data[i] == c {
+				i++
+			}
 			continue
 		}
 
@@ -1246,22 +1448,67 @@ func helperTripleEmphasis(p *Parser, data []byte, offset int, c byte) (int, ast.
 	return 0, nil
 }
 
+// underscoreInWord returns true if c is _, the NoIntraUnderscore extension
+// is set and the run of c starting at data[i] is followed by a letter or
+// digit, so it can't close emphasis.
+func underscoreInWord(p *Parser, data []byte, i int, c byte) bool {
+	if c != '_' || p.extensions&NoIntraUnderscore == 0 {
+		return false
+	}
+	for i < len(data) && data[i] == c {
+		i++
+	}
+	return i < len(data) && isWordAt(data, i)
+}
+
+// strikethroughInWord returns true if c is '~', the NoIntraStrikethrough
+// extension is set and the run of c starting at data[i] is followed by a
+// letter or digit, so it can't close strikethrough.
+func strikethroughInWord(p *Parser, data []byte, i int, c byte) bool {
+	if c != '~' || p.extensions&NoIntraStrikethrough == 0 {
+		return false
+	}
+	for i < len(data) && data[i] == c {
+		i++
+	}
+	return i < len(data) && isWordAt(data, i)
+}
+
 // math handle inline math wrapped with '$'
+//
+// Like in pandoc, the opening $ must be followed by a non-space and the
+// closing $ preceded by a non-space and not followed by a digit, so that
+// amounts like $5 and $10 aren't math.
 func math(p *Parser, data []byte, offset int) (int, ast.Node) {
 	data = data[offset:]
 
 	// too short, or block math
-	if len(data) <= 2 || data[1] == '$' {
+	if len(data) <= 2 || data[1] == '$' || isSpace(data[1]) {
+		return 0, nil
+	}
+
+	// a closing '$' for this opener would also close an earlier one, so
+	// don't scan again if one before it found none in the same data
+	last := &data[len(data)-1]
+	if last == p.mathUnclosedEnd && len(data) <= p.mathUnclosedLen {
 		return 0, nil
 	}
 
-	// find next '$'
+	// find the closing '$'
 	var end int
-	for end = 1; end < len(data) && data[end] != '$'; end++ {
+	for end = 1; end < len(data); end++ {
+		if data[end] != '$' || isSpace(data[end-1]) {
+			continue
+		}
+		if end+1 < len(data) && data[end+1] >= '0' && data[end+1] <= '9' {
+			continue
+		}
+		break
 	}
 
 	// $ not match
 	if end == len(data) {
+		p.mathUnclosedEnd, p.mathUnclosedLen = last, len(data)
 		return 0, nil
 	}
 
@@ -1272,7 +1519,7 @@ func math(p *Parser, data []byte, offset int) (int, ast.Node) {
 }
 
 func newTextNode(d []byte) *ast.Text {
-	return &ast.Text{ast.Leaf{Literal: d}}
+	return &ast.Text{Leaf: ast.Leaf{Literal: d}}
 }
 
 func normalizeURI(s []byte) []byte {
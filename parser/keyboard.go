@@ -0,0 +1,30 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// keyboardKey parses a keyboard key written as [[[Ctrl]]] with the Keyboard
+// extension. The third bracket keeps it apart from [[Page]] wiki links, so
+// both extensions can be used together.
+func keyboardKey(data []byte, offset int) (int, ast.Node) {
+	data = data[offset:]
+	if len(data) < 7 || !bytes.HasPrefix(data, []byte("[[[")) {
+		return 0, nil
+	}
+
+	end := bytes.Index(data[3:], []byte("]]]"))
+	if end < 0 {
+		return 0, nil
+	}
+	key := data[3 : 3+end]
+	if bytes.ContainsAny(key, "[]\n") || len(bytes.TrimSpace(key)) == 0 {
+		return 0, nil
+	}
+
+	kbd := &ast.Kbd{}
+	kbd.Literal = bytes.TrimSpace(key)
+	return 3 + end + 3, kbd
+}
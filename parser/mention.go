@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// mention parses @username and #hashtag. The destination of the link is
+// built by Options.MentionFn or Options.HashtagFn, if that isn't set the
+// text is left alone.
+func mention(p *Parser, data []byte, offset int) (int, ast.Node) {
+	c := data[offset]
+	fn := p.Opts.MentionFn
+	if c == '#' {
+		fn = p.Opts.HashtagFn
+	}
+	if fn == nil || p.insideLink {
+		return 0, nil
+	}
+
+	// must start a word: not part of an email address, an issue#12 or @@
+	if offset > 0 {
+		prev := data[offset-1]
+		if isAlnum(prev) || prev == '_' || prev == '@' || prev == '#' || prev == '/' || prev == '\\' {
+			return 0, nil
+		}
+	}
+
+	data = data[offset:]
+	end := 1
+	hasLetter := false
+	for end < len(data) && (isAlnum(data[end]) || data[end] == '_' || data[end] == '-') {
+		if isLetter(data[end]) {
+			hasLetter = true
+		}
+		end++
+	}
+	// don't end on a dash, e.g. "@bob- the builder"
+	for end > 1 && data[end-1] == '-' {
+		end--
+	}
+	if end == 1 || (c == '#' && !hasLetter) {
+		return 0, nil
+	}
+
+	name := data[1:end]
+	link := &ast.Link{
+		Destination: fn(name),
+	}
+	ast.AppendChild(link, newTextNode(data[:end]))
+	return end, link
+}
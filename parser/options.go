@@ -11,14 +11,46 @@ type Flags int
 type Options struct {
 	ParserHook    BlockFunc
 	ReadIncludeFn ReadIncludeFunc
+	WikiLinkFn    WikiLinkFunc
+
+	// MentionFn and HashtagFn return the link destination of @username and
+	// #hashtag (without the @ or #) with the Mentions extension. If not set,
+	// mentions or hashtags are not linked.
+	MentionFn LinkFunc
+	HashtagFn LinkFunc
+
+	// Emoji maps shortcodes, without the colons, to the text replacing them
+	// with the Emoji extension. If nil, DefaultEmoji is used. CustomEmoji maps
+	// shortcodes to image URLs and takes precedence over Emoji.
+	Emoji       map[string]string
+	CustomEmoji map[string]string
+
+	// DefaultCodeLanguage, if set, is used as the info string of indented
+	// code blocks, which otherwise have no language.
+	DefaultCodeLanguage string
+
+	// References are link reference definitions supplied by the host
+	// application, e.g. a shared glossary, keyed by reference id. They
+	// resolve [text][id] and [id] links like the definitions in the document,
+	// which take precedence over them.
+	References map[string]Reference
+
+	// MaxSteps, if > 0, bounds the work done parsing, counted in blocks
+	// parsed and bytes scanned, to protect against crafted input blowing
+	// up e.g. emphasis scanning. Once it's exceeded, parsing stops: Parse
+	// returns the document parsed so far and ParseContext returns
+	// ErrTooManySteps.
+	MaxSteps int
 
 	Flags Flags // Flags allow customizing parser's behavior
 }
 
 // Parser renderer configuration options.
 const (
-	FlagsNone        Flags = 0
-	SkipFootnoteList Flags = 1 << iota // Skip adding the footnote list (regardless if they are parsed)
+	FlagsNone          Flags = 0
+	SkipFootnoteList   Flags = 1 << iota // Skip adding the footnote list (regardless if they are parsed)
+	SourcePositions                      // Set the SourceOffset of top-level blocks, -1 for other nodes
+	KeepCodeSpanSpaces                   // Don't strip the space on each side of a code span's content
 )
 
 // BlockFunc allows to registration of a parser function. If successful it
@@ -30,3 +62,11 @@ type BlockFunc func(data []byte) (ast.Node, []byte, int)
 // this will be empty. address is the optional address specifier of which lines
 // of the file to return. If this function is not set no data will be read.
 type ReadIncludeFunc func(from, path string, address []byte) []byte
+
+// WikiLinkFunc returns the link destination of a wiki link to page. If it is
+// not set, the destination is the page name lowercased, with runs of
+// non-alphanumeric characters replaced by a dash.
+type WikiLinkFunc func(page []byte) []byte
+
+// LinkFunc returns the link destination for name.
+type LinkFunc func(name []byte) []byte
@@ -2,6 +2,9 @@ package parser
 
 import (
 	"bytes"
+	"context"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/gomarkdown/markdown/ast"
@@ -62,3 +65,177 @@ hallo
 		}
 	}
 }
+
+// admonitionBlock parses a "!!! kind" line followed by lines indented with
+// four spaces into an aside with the classes admonition and kind.
+func admonitionBlock(data []byte) (ast.Node, []byte, int) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		end = len(data)
+	}
+	kind := bytes.TrimSpace(data[len("!!! "):end])
+	if len(kind) == 0 {
+		return nil, nil, 0
+	}
+
+	var content []byte
+	i := end + 1
+	for i < len(data) {
+		end = bytes.IndexByte(data[i:], '\n')
+		if end < 0 {
+			end = len(data)
+		} else {
+			end += i
+		}
+		line := data[i:end]
+		if len(bytes.TrimSpace(line)) > 0 && !bytes.HasPrefix(line, []byte("    ")) {
+			break
+		}
+		content = append(content, bytes.TrimPrefix(line, []byte("    "))...)
+		content = append(content, '\n')
+		i = end + 1
+	}
+	if i > len(data) {
+		i = len(data)
+	}
+
+	aside := &ast.Aside{}
+	aside.Attribute = &ast.Attribute{Classes: [][]byte{[]byte("admonition"), kind}}
+	return aside, content, i
+}
+
+func TestRegisterBlock(t *testing.T) {
+	data := []byte(`# Title
+
+!!! note
+    Some *text*.
+
+    More text.
+
+!!!
+Not a note.
+
+* list
+`)
+	want := `Heading
+  Text 'Title'
+Aside
+  Paragraph
+    Text 'Some'
+    Emph
+      Text 'text'
+    Text '.'
+  Paragraph
+    Text 'More text.'
+Paragraph
+  Text '!!!'
+  Softbreak
+  Text 'Not a note.'
+List 'tight flags=start'
+  ListItem 'flags=start'
+    Paragraph
+      Text 'list'
+`
+
+	p := New()
+	p.RegisterBlock([]byte("!!! "), admonitionBlock)
+	doc := p.Parse(data)
+	got := ast.ToString(doc)
+	if got != want {
+		t.Errorf("want ast\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestParseContextError(t *testing.T) {
+	data := []byte("# Title\n\n* list\n\n!!! boom\n")
+	broken := func(data []byte) (ast.Node, []byte, int) {
+		panic("broken hook")
+	}
+
+	p := New()
+	p.RegisterBlock([]byte("!!! "), broken)
+	doc, err := p.ParseContext(context.Background(), data)
+	perr, ok := err.(*Error)
+	if !ok || doc != nil {
+		t.Fatalf("want *Error and no document, got %v, %v", doc, err)
+	}
+	if perr.Offset != 17 || perr.Line != 5 || perr.Msg != "broken hook" {
+		t.Errorf("got offset %d, line %d, message %q", perr.Offset, perr.Line, perr.Msg)
+	}
+
+	// malformed input isn't an error
+	for _, input := range []string{"", "[]:<", "   [", "* [a]\n\n  > *b", string(data)} {
+		doc, err := New().ParseContext(context.Background(), []byte(input))
+		if err != nil || doc == nil {
+			t.Errorf("ParseContext(%q) = %v, %v", input, doc, err)
+		}
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	// unclosed emphasis makes every * scan to the end of the paragraph
+	data := []byte(strings.Repeat("*a ", 5000))
+
+	p := New()
+	p.Opts.MaxSteps = 100000
+	doc, err := p.ParseContext(context.Background(), data)
+	if err != ErrTooManySteps || doc != nil {
+		t.Errorf("got %v, %v, want error %v", doc, err, ErrTooManySteps)
+	}
+
+	p = New()
+	p.Opts.MaxSteps = 100000
+	if doc := p.Parse(data); doc == nil {
+		t.Errorf("Parse returned no document")
+	}
+
+	// running out of steps anywhere stops parsing cleanly
+	inputs := []string{
+		"hello world\n",
+		"# Title\n\nSome *emphasis* and **strong** text with [a link](/url).\n",
+		"* list\n* items\n\n> quote\n\n| a | b |\n|---|---|\n| 1 | 2 |\n",
+	}
+	for _, input := range inputs {
+		maxSteps := 1
+		for ; maxSteps <= 1000; maxSteps++ {
+			p := New()
+			p.Opts.MaxSteps = maxSteps
+			doc, err := p.ParseContext(context.Background(), []byte(input))
+			if err == nil && doc != nil {
+				break
+			}
+			if err != ErrTooManySteps || doc != nil {
+				t.Errorf("MaxSteps %d, %q: got %v, %v, want error %v", maxSteps, input, doc, err, ErrTooManySteps)
+			}
+		}
+		if maxSteps < len(input) || maxSteps > 1000 {
+			t.Errorf("%q parsed with MaxSteps %d", input, maxSteps)
+		}
+	}
+
+	for _, maxSteps := range []int{0, 100000} {
+		p := New()
+		p.Opts.MaxSteps = maxSteps
+		input := []byte("# Title\n\nSome *emphasis* and **strong** text.\n")
+		doc, err := p.ParseContext(context.Background(), input)
+		if err != nil || doc == nil {
+			t.Errorf("MaxSteps %d: got %v, %v", maxSteps, doc, err)
+		}
+	}
+}
+
+func ExampleParser_RegisterBlock() {
+	p := New()
+	p.RegisterBlock([]byte("!!! "), admonitionBlock)
+	doc := p.Parse([]byte("!!! warning\n    Mind the *gap*.\n\nBack to normal.\n"))
+	ast.Print(os.Stdout, doc)
+	// Output:
+	// Aside
+	//   Paragraph
+	//     Text 'Mind the'
+	//     Emph
+	//       Text 'gap'
+	//     Text '.'
+	// Paragraph
+	//   Text 'Back to normal.'
+}
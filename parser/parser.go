@@ -5,8 +5,11 @@ package parser
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/gomarkdown/markdown/ast"
@@ -42,6 +45,22 @@ const (
 	EmptyLinesBreakList                           // 2 empty lines break out of list
 	Includes                                      // Support including other files.
 	Mmark                                         // Support Mmark syntax, see https://mmark.nl/syntax
+	ValidateEntities                              // Decode known and numeric entities, escape unknown ones
+	WikiLinks                                     // Parse [[Page]] and [[Page|Label]] wiki links
+	Mentions                                      // Link @username and #hashtag, see Options.MentionFn and Options.HashtagFn
+	Emoji                                         // Replace :shortcode: with emoji, see Options.Emoji
+	TableColSpan                                  // Merge trailing empty cells of a table row into a colspan of the last cell
+	StrictCommonMark                              // Parse * and _ emphasis with the CommonMark delimiter run rules
+	NoIntraUnderscore                             // Ignore _ emphasis markers inside words, * still works
+	Highlight                                     // Highlight text using ==mark==
+	Insert                                        // Inserted text using ++ins++
+	Abbreviations                                 // Parse *[ABBR]: title definitions and mark the abbreviations
+	NoTrailingSpaceBreak                          // Don't translate two or more trailing spaces into line breaks
+	ImageSize                                     // Parse size hints like ![alt](src =100x200) of images
+	NoIntraStrikethrough                          // Ignore ~~ strikethrough markers inside words
+	Keyboard                                      // Parse [[[Ctrl]]] keyboard keys
+	Alerts                                        // Parse GitHub alerts, blockquotes starting with [!NOTE], [!WARNING] etc.
+	EmptyLineBeforeTable                          // Only parse tables after an empty line or at the start of their container
 
 	CommonExtensions Extensions = NoIntraEmphasis | Tables | FencedCode |
 		Autolink | Strikethrough | SpaceHeadings | HeadingIDs |
@@ -63,6 +82,12 @@ type inlineParser func(p *Parser, data []byte, offset int) (int, ast.Node)
 // See the documentation in Options for more details on use-case.
 type ReferenceOverrideFunc func(reference string) (ref *Reference, overridden bool)
 
+// MissingReferenceFunc is called with the id of a reference style link that
+// has no definition in the document. It returns the link destination and
+// title, and ok set to true if it could resolve the reference. If ok is
+// false the link is output verbatim.
+type MissingReferenceFunc func(id []byte) (url, title []byte, ok bool)
+
 // Parser is a type that holds extensions and the runtime state used by
 // Parse, and the renderer. You can not use it directly, construct it with New.
 type Parser struct {
@@ -83,6 +108,23 @@ type Parser struct {
 	// the bottom will be used to fill in the link details.
 	ReferenceOverride ReferenceOverrideFunc
 
+	// OnMissingRef is an optional function callback that is called for
+	// reference style links, including the [refid] shortcut form, whose refid
+	// isn't defined in the document. It allows resolving references
+	// dynamically, e.g. from an index of wiki pages.
+	OnMissingRef MissingReferenceFunc
+
+	// InlineFallback is an optional function called for the bytes of spans
+	// that no inline parser handles: there is no built-in one for them with
+	// the enabled extensions and none was added with RegisterInline. It
+	// follows the InlineFunc contract and is a lighter-weight alternative to
+	// RegisterInline when the bytes to intercept aren't known in advance.
+	//
+	// It's called for every such byte, so it should return 0 quickly for the
+	// ones it doesn't handle. Bytes with a parser never reach it, even when
+	// that parser returns 0.
+	InlineFallback InlineFunc
+
 	Opts Options
 
 	// after parsing, this is AST root of parsed markdown text
@@ -91,12 +133,22 @@ type Parser struct {
 	extensions Extensions
 
 	refs           map[string]*reference
+	optRefs        map[string]*reference // Opts.References by refKey, built on first use
+	abbrs          map[string][]byte
 	refsRecord     map[string]struct{}
 	inlineCallback [256]inlineParser
+	blockHandlers  []blockHandler
 	nesting        int
 	maxNesting     int
 	insideLink     bool
-	indexCnt       int // incremented after every index
+	insideCell     bool         // a backslash newline in a table cell is always a break
+	delims         []*delimiter // emphasis delimiters waiting to be matched, used for StrictCommonMark
+	indexCnt       int          // incremented after every index
+
+	// math found no closing $ in the last mathUnclosedLen bytes of the data
+	// ending at mathUnclosedEnd, so later $ in them can't open math either
+	mathUnclosedEnd *byte
+	mathUnclosedLen int
 
 	// Footnotes need to be ordered as well as available to quickly check for
 	// presence. If a ref is also a footnote, it's stored both in refs and here
@@ -112,6 +164,36 @@ type Parser struct {
 	attr *ast.Attribute
 
 	includeStack *incStack
+
+	// set by ParseContext; once ctx is done, err is set and parsing stops
+	ctx       context.Context
+	ctxChecks int
+	err       error
+
+	steps int // work done parsing, for Options.MaxSteps
+
+	// input given to Parse and the offset and line number in it of the
+	// top-level block being parsed, used to report where parsing failed and
+	// for SourcePositions
+	input       []byte
+	blockOffset int
+	blockLine   int
+	lineOffset  int // offset up to which lines were counted in blockLine
+	positioned  int // number of top-level blocks with a SourceOffset
+}
+
+// Error is returned by ParseContext when parsing fails because of an
+// internal error, e.g. a panic in the parser or in a user supplied hook.
+type Error struct {
+	// Offset and Line are the byte offset and the line number, starting at
+	// 1, in the input of the top-level block that was being parsed
+	Offset int
+	Line   int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("markdown: parse error at line %d (offset %d): %s", e.Line, e.Offset, e.Msg)
 }
 
 // New creates a markdown parser with CommonExtensions.
@@ -142,9 +224,15 @@ func NewWithExtensions(extension Extensions) *Parser {
 	p.inlineCallback[' '] = maybeLineBreak
 	p.inlineCallback['*'] = emphasis
 	p.inlineCallback['_'] = emphasis
-	if p.extensions&Strikethrough != 0 {
+	if p.extensions&(Strikethrough|SuperSubscript) != 0 {
 		p.inlineCallback['~'] = emphasis
 	}
+	if p.extensions&Highlight != 0 {
+		p.inlineCallback['='] = emphasis
+	}
+	if p.extensions&Insert != 0 {
+		p.inlineCallback['+'] = emphasis
+	}
 	p.inlineCallback['`'] = codeSpan
 	p.inlineCallback['\n'] = lineBreak
 	p.inlineCallback['['] = link
@@ -167,10 +255,61 @@ func NewWithExtensions(extension Extensions) *Parser {
 	if p.extensions&MathJax != 0 {
 		p.inlineCallback['$'] = math
 	}
+	if p.extensions&Emoji != 0 {
+		p.inlineCallback[':'] = emoji
+	}
+	if p.extensions&Mentions != 0 {
+		p.inlineCallback['@'] = mention
+		p.inlineCallback['#'] = mention
+	}
 
 	return &p
 }
 
+type blockHandler struct {
+	prefix []byte
+	fn     BlockFunc
+}
+
+// RegisterBlock adds a parser for a custom block syntax starting with prefix,
+// e.g. "!!! " for admonitions or spoilers.
+//
+// fn follows the Options.ParserHook contract: it's called with the data
+// starting at the beginning of the block and returns the node to add to the
+// document, the data to parse as the content of that node (or nil) and the
+// number of bytes consumed. If the data isn't a block it handles, it returns
+// 0 and the next parser is tried.
+//
+// Custom blocks are tried before the built-in block syntaxes, after
+// Options.ParserHook, in the order they were registered.
+func (p *Parser) RegisterBlock(prefix []byte, fn BlockFunc) {
+	p.blockHandlers = append(p.blockHandlers, blockHandler{prefix, fn})
+}
+
+// InlineFunc parses an inline element starting at data[offset], where data
+// is the text of the whole span being parsed. It returns the number of bytes
+// consumed and the node to add, or 0 if data[offset:] isn't an element it
+// handles. Use Parser.Inline to parse the content of the element.
+type InlineFunc func(p *Parser, data []byte, offset int) (int, ast.Node)
+
+// RegisterInline adds a parser for a custom inline syntax starting with the
+// byte c, e.g. '=' for ==highlight==.
+//
+// If c already starts a built-in or a previously registered element, fn is
+// tried first and the existing parser is only used if fn returns 0.
+func (p *Parser) RegisterInline(c byte, fn InlineFunc) {
+	prev := p.inlineCallback[c]
+	p.inlineCallback[c] = func(p *Parser, data []byte, offset int) (int, ast.Node) {
+		if consumed, node := fn(p, data, offset); consumed > 0 {
+			return consumed, node
+		}
+		if prev != nil {
+			return prev(p, data, offset)
+		}
+		return 0, nil
+	}
+}
+
 func (p *Parser) getRef(refid string) (ref *reference, found bool) {
 	if p.ReferenceOverride != nil {
 		r, overridden := p.ReferenceOverride(refid)
@@ -187,10 +326,53 @@ func (p *Parser) getRef(refid string) (ref *reference, found bool) {
 		}
 	}
 	// refs are case insensitive
-	ref, found = p.refs[strings.ToLower(refid)]
+	key := refKey(refid)
+	if ref, found = p.refs[key]; found {
+		return ref, found
+	}
+	return p.getOptionsRef(key)
+}
+
+// getOptionsRef returns the reference with key in Opts.References.
+func (p *Parser) getOptionsRef(key string) (ref *reference, found bool) {
+	if len(p.Opts.References) == 0 {
+		return nil, false
+	}
+	if p.optRefs == nil {
+		p.optRefs = make(map[string]*reference, len(p.Opts.References))
+		for id, r := range p.Opts.References {
+			p.optRefs[refKey(id)] = &reference{
+				link:  []byte(r.Link),
+				title: []byte(r.Title),
+				text:  []byte(r.Text),
+			}
+		}
+	}
+	ref, found = p.optRefs[key]
 	return ref, found
 }
 
+// refKey returns the key of the reference id in refs. Ids are matched case
+// insensitively. Folding through upper case first makes ids that only
+// differ in letters with several lower case forms, like the Greek final
+// sigma in ΣΑΣ and σας, match as well.
+func refKey(id string) string {
+	return strings.ToLower(strings.ToUpper(id))
+}
+
+// getMissingRef resolves a reference that isn't defined in the document with
+// OnMissingRef.
+func (p *Parser) getMissingRef(refid []byte) (ref *reference, found bool) {
+	if p.OnMissingRef == nil {
+		return nil, false
+	}
+	link, title, ok := p.OnMissingRef(refid)
+	if !ok {
+		return nil, false
+	}
+	return &reference{link: link, title: title}, true
+}
+
 func (p *Parser) isFootnote(ref *reference) bool {
 	_, ok := p.refsRecord[string(ref.link)]
 	return ok
@@ -263,27 +445,99 @@ type Reference struct {
 // You can then convert AST to html using html.Renderer, to some other format
 // using a custom renderer or transform the tree.
 func (p *Parser) Parse(input []byte) ast.Node {
+	// block parsers expect every line, including the last one, to end with a
+	// newline
+	if n := len(input); n > 0 && input[n-1] != '\n' {
+		input = append(input[:n:n], '\n')
+	}
+	p.input = input
+	p.blockOffset, p.blockLine, p.lineOffset = 0, 1, 0
+	p.steps = 0
+	p.mathUnclosedEnd, p.mathUnclosedLen = nil, 0
 	p.block(input)
+	p.input = nil
 	// Walk the tree and finish up some of unfinished blocks
 	for p.tip != nil {
 		p.finalize(p.tip)
 	}
 	// Walk the tree again and process inline markdown in each block
 	ast.WalkFunc(p.Doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if p.canceled() {
+			return ast.Terminate
+		}
 		switch node.(type) {
 		case *ast.Paragraph, *ast.Heading, *ast.TableCell:
+			_, p.insideCell = node.(*ast.TableCell)
 			p.Inline(node, node.AsContainer().Content)
 			node.AsContainer().Content = nil
 		}
 		return ast.GoToNext
 	})
 
+	if len(p.abbrs) > 0 {
+		p.markAbbreviations()
+	}
+
 	if p.Opts.Flags&SkipFootnoteList == 0 {
 		p.parseRefsToAST()
 	}
+	p.setUnknownSourceOffsets()
 	return p.Doc
 }
 
+// ParseContext is like Parse but gives up when ctx is canceled or its
+// deadline passes, returning ctx.Err(). The context is checked every few
+// blocks, so parsing stops promptly even for very large inputs.
+//
+// Instead of panicking on internal errors, ParseContext returns an *Error.
+func (p *Parser) ParseContext(ctx context.Context, input []byte) (doc ast.Node, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			doc, err = nil, &Error{Offset: p.blockOffset, Line: p.blockLine, Msg: fmt.Sprint(r)}
+		}
+	}()
+	p.ctx = ctx
+	doc = p.Parse(input)
+	if p.err != nil {
+		return nil, p.err
+	}
+	return doc, nil
+}
+
+// ErrTooManySteps is returned by ParseContext when parsing takes more than
+// Options.MaxSteps steps.
+var ErrTooManySteps = errors.New("markdown: parsing takes too many steps")
+
+// step counts n steps of parsing work and returns true if parsing must stop
+// because Options.MaxSteps is exceeded or for another reason.
+func (p *Parser) step(n int) bool {
+	p.steps += n
+	if p.Opts.MaxSteps > 0 && p.steps > p.Opts.MaxSteps && p.err == nil {
+		p.err = ErrTooManySteps
+	}
+	return p.err != nil
+}
+
+// ctxCheckInterval is how many blocks are parsed between checks of the
+// context given to ParseContext
+const ctxCheckInterval = 64
+
+// canceled returns true if parsing must stop because Options.MaxSteps is
+// exceeded or the context given to ParseContext is done.
+func (p *Parser) canceled() bool {
+	if p.step(1) || p.ctx == nil {
+		return p.err != nil
+	}
+	if p.ctxChecks%ctxCheckInterval == 0 {
+		p.err = p.ctx.Err()
+	}
+	p.ctxChecks++
+	return p.err != nil
+}
+
 func (p *Parser) parseRefsToAST() {
 	if p.extensions&Footnotes == 0 || len(p.notes) == 0 {
 		return
@@ -510,10 +764,12 @@ func isReference(p *Parser, data []byte, tabSize int) int {
 		ref.title = data[titleOffset:titleEnd]
 	}
 
-	// id matches are case-insensitive
-	id := string(bytes.ToLower(data[idOffset:idEnd]))
-
-	p.refs[id] = ref
+	// id matches are case-insensitive. As in Markdown.pl the first definition
+	// of an id wins, later ones are dropped.
+	id := refKey(string(data[idOffset:idEnd]))
+	if _, found := p.refs[id]; !found {
+		p.refs[id] = ref
+	}
 
 	return lineEnd
 }
@@ -572,12 +828,13 @@ func scanLinkRef(p *Parser, data []byte, i int) (linkOffset, linkEnd, titleOffse
 			titleEnd = i
 		}
 
-		// step back
+		// step back to the closing quote, which may directly follow the
+		// opening one for an empty title
 		i--
 		for i > titleOffset && (data[i] == ' ' || data[i] == '\t') {
 			i--
 		}
-		if i > titleOffset && (data[i] == '\'' || data[i] == '"' || data[i] == ')') {
+		if i >= titleOffset && (data[i] == '\'' || data[i] == '"' || data[i] == ')') {
 			lineEnd = titleEnd
 			titleEnd = i
 		}
@@ -681,6 +938,65 @@ func isSpace(c byte) bool {
 	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
 }
 
+// isSpaceAt returns true if the rune starting at data[i] is a white-space
+// character, including non-ASCII ones like no-break or ideographic space
+func isSpaceAt(data []byte, i int) bool {
+	if data[i] < utf8.RuneSelf {
+		return isSpace(data[i])
+	}
+	r, _ := utf8.DecodeRune(data[i:])
+	return unicode.IsSpace(r)
+}
+
+// isSpaceBefore returns true if the rune ending just before data[i] is a
+// white-space character
+func isSpaceBefore(data []byte, i int) bool {
+	if data[i-1] < utf8.RuneSelf {
+		return isSpace(data[i-1])
+	}
+	r, _ := utf8.DecodeLastRune(data[:i])
+	return unicode.IsSpace(r)
+}
+
+// isPunctuationAt returns true if the rune starting at data[i] is a
+// punctuation or symbol character, e.g. an em-dash or a CJK full stop
+func isPunctuationAt(data []byte, i int) bool {
+	if data[i] < utf8.RuneSelf {
+		return isPunctuation(data[i])
+	}
+	r, _ := utf8.DecodeRune(data[i:])
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// isPunctuationBefore returns true if the rune ending just before data[i] is
+// a punctuation or symbol character
+func isPunctuationBefore(data []byte, i int) bool {
+	if data[i-1] < utf8.RuneSelf {
+		return isPunctuation(data[i-1])
+	}
+	r, _ := utf8.DecodeLastRune(data[:i])
+	return unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// isWordAt returns true if the rune starting at data[i] is a letter or digit
+func isWordAt(data []byte, i int) bool {
+	if data[i] < utf8.RuneSelf {
+		return isAlnum(data[i])
+	}
+	r, _ := utf8.DecodeRune(data[i:])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isWordBefore returns true if the rune ending just before data[i] is a
+// letter or digit
+func isWordBefore(data []byte, i int) bool {
+	if data[i-1] < utf8.RuneSelf {
+		return isAlnum(data[i-1])
+	}
+	r, _ := utf8.DecodeLastRune(data[:i])
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
 // isLetter returns true if c is ascii letter
 func isLetter(c byte) bool {
 	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
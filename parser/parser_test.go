@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/gomarkdown/markdown/ast"
 )
 
 func TestIsFenceLine(t *testing.T) {
@@ -107,3 +110,70 @@ func TestSanitizedAnchorName(t *testing.T) {
 		}
 	}
 }
+
+func TestSourcePositions(t *testing.T) {
+	input := "# Title\n\nSome\ntext.\n\n* a\n* b\n\n```\ncode\n```\n\n> quote\n\n---\n\n" +
+		"| a | b |\n|---|---|\n| c | d |\n\nnote[^1]\n\n[^1]: foot\n"
+	p := NewWithExtensions(CommonExtensions | Footnotes)
+	p.Opts.Flags |= SourcePositions
+	doc := p.Parse([]byte(input))
+
+	want := []struct {
+		typ    string
+		offset int
+		line   int
+	}{
+		{"*ast.Heading", 0, 1},
+		{"*ast.Paragraph", 9, 3},
+		{"*ast.List", 21, 6},
+		{"*ast.CodeBlock", 30, 9},
+		{"*ast.BlockQuote", 44, 13},
+		{"*ast.HorizontalRule", 53, 15},
+		{"*ast.Table", 58, 17},
+		{"*ast.Paragraph", 89, 21},
+		// the footnotes are added after parsing
+		{"*ast.Footnotes", -1, -1},
+		{"*ast.List", -1, -1},
+	}
+	children := doc.GetChildren()
+	if len(children) != len(want) {
+		t.Fatalf("got %d blocks, want %d", len(children), len(want))
+	}
+	for i, child := range children {
+		var offset, line int
+		if c := child.AsContainer(); c != nil {
+			offset, line = c.SourceOffset, c.SourceLine
+		} else {
+			offset, line = child.AsLeaf().SourceOffset, child.AsLeaf().SourceLine
+		}
+		typ := fmt.Sprintf("%T", child)
+		if typ != want[i].typ || offset != want[i].offset || line != want[i].line {
+			t.Errorf("block %d: got %s at %d line %d, want %s at %d line %d",
+				i, typ, offset, line, want[i].typ, want[i].offset, want[i].line)
+		}
+	}
+
+	// nested blocks and inline nodes have no position
+	seen := map[string]bool{}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if !entering || node == doc || node.GetParent() == doc {
+			return ast.GoToNext
+		}
+		seen[fmt.Sprintf("%T", node)] = true
+		var offset, line int
+		if c := node.AsContainer(); c != nil {
+			offset, line = c.SourceOffset, c.SourceLine
+		} else {
+			offset, line = node.AsLeaf().SourceOffset, node.AsLeaf().SourceLine
+		}
+		if offset != -1 || line != -1 {
+			t.Errorf("nested %T: got %d line %d, want -1 line -1", node, offset, line)
+		}
+		return ast.GoToNext
+	})
+	for _, typ := range []string{"*ast.ListItem", "*ast.TableCell", "*ast.Text", "*ast.Link"} {
+		if !seen[typ] {
+			t.Errorf("no nested %s checked", typ)
+		}
+	}
+}
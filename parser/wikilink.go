@@ -0,0 +1,54 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// wikiLink parses a wiki link: [[Page]] or [[Page|Label]]. The destination
+// is built from the page name by Options.WikiLinkFn, the label defaults to
+// the page name.
+func wikiLink(p *Parser, data []byte, offset int) (int, ast.Node) {
+	data = data[offset:]
+	if len(data) < 5 || data[0] != '[' || data[1] != '[' {
+		return 0, nil
+	}
+
+	end := bytes.Index(data[2:], []byte("]]"))
+	if end < 0 {
+		return 0, nil
+	}
+	content := data[2 : 2+end]
+	if bytes.IndexByte(content, '\n') >= 0 || bytes.IndexByte(content, '[') >= 0 {
+		return 0, nil
+	}
+
+	page, label := content, content
+	if i := bytes.IndexByte(content, '|'); i >= 0 {
+		page, label = content[:i], content[i+1:]
+	}
+	page = bytes.TrimSpace(page)
+	label = bytes.TrimSpace(label)
+	if len(page) == 0 || len(label) == 0 {
+		return 0, nil
+	}
+
+	var dest []byte
+	if p.Opts.WikiLinkFn != nil {
+		dest = p.Opts.WikiLinkFn(page)
+	} else {
+		dest = []byte(sanitizeAnchorName(string(page)))
+	}
+
+	link := &ast.Link{
+		Destination: dest,
+	}
+	// links cannot contain other links
+	insideLink := p.insideLink
+	p.insideLink = true
+	p.Inline(link, label)
+	p.insideLink = insideLink
+
+	return 2 + end + 2, link
+}
@@ -1,8 +1,10 @@
 package markdown
 
 import (
+	"fmt"
 	"io/ioutil"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/gomarkdown/markdown/parser"
@@ -166,3 +168,23 @@ func BenchmarkReference(b *testing.B) {
 		}
 	}
 }
+
+// references are kept in a map keyed by the case folded id, so lookups don't
+// slow down as the number of definitions grows
+func BenchmarkManyReferences(b *testing.B) {
+	params := TestParams{extensions: parser.CommonExtensions}
+	var sb strings.Builder
+	const n = 5000
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "[link %d][Ref%d]\n", i, n-i)
+	}
+	sb.WriteString("\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "[ref%d]: /url/%d\n", i, i)
+	}
+	test := sb.String()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		benchResultAnchor = runMarkdown(test, params)
+	}
+}
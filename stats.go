@@ -0,0 +1,58 @@
+package markdown
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// Stats returns the number of words and characters in the text of a markdown
+// document. Only text that ends up readable in the output is counted: code,
+// HTML, image alt text and URLs of autolinks are skipped. Characters are
+// counted as runes and include the spaces within the text.
+//
+// The words count can be used to estimate reading time, e.g. at 200 words per
+// minute.
+func Stats(input []byte) (words, chars int) {
+	p := parser.NewWithExtensions(parser.CommonExtensions)
+	doc := p.Parse(input)
+
+	var buf bytes.Buffer
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch n := node.(type) {
+		case *ast.Text:
+			buf.Write(n.Literal)
+			chars += utf8.RuneCount(n.Literal)
+		case *ast.Image:
+			return ast.SkipChildren
+		case *ast.Link:
+			if entering && isAutoLink(n) {
+				return ast.SkipChildren
+			}
+		case *ast.Softbreak, *ast.Hardbreak:
+			buf.WriteByte('\n')
+		case *ast.Paragraph, *ast.Heading, *ast.TableCell, *ast.ListItem, *ast.Caption:
+			// don't join the last word of a block with the first of the next
+			buf.WriteByte('\n')
+		}
+		return ast.GoToNext
+	})
+	words = len(bytes.Fields(buf.Bytes()))
+	return words, chars
+}
+
+// isAutoLink returns true if the link text is the destination itself.
+func isAutoLink(link *ast.Link) bool {
+	children := link.GetChildren()
+	if len(children) != 1 {
+		return false
+	}
+	text, ok := children[0].(*ast.Text)
+	if !ok {
+		return false
+	}
+	dest := bytes.TrimPrefix(link.Destination, []byte("mailto:"))
+	return bytes.Equal(text.Literal, dest) || bytes.Equal(text.Literal, link.Destination)
+}
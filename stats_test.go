@@ -0,0 +1,27 @@
+package markdown
+
+import "testing"
+
+func TestStats(t *testing.T) {
+	tests := []struct {
+		input string
+		words int
+		chars int
+	}{
+		{"", 0, 0},
+		{"Hello world\n", 2, 11},
+		{"# Title\n\nSome *emphasized* text here.\n", 5, 31},
+		{"Prose before.\n\n```go\nfunc main() {\n\tprintln(\"not counted\")\n}\n```\n\nAnd `inline code` after.\n", 4, 24},
+		{"Visit <http://example.com> or [the site](http://example.com).\n", 4, 19},
+		{"![an image](/a.png) caption\n", 1, 8},
+		{"* one\n* two\n\n| a | b |\n|---|---|\n| c | d |\n", 6, 10},
+		{"héllo wörld\n", 2, 11},
+	}
+	for _, test := range tests {
+		words, chars := Stats([]byte(test.input))
+		if words != test.words || chars != test.chars {
+			t.Errorf("Stats(%q) = %d words, %d chars, expected %d words, %d chars",
+				test.input, words, chars, test.words, test.chars)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// ProblemKind is the kind of a Problem reported by Validate.
+type ProblemKind int
+
+const (
+	UnresolvedReference ProblemKind = iota // [text][id] or [id][] link without a definition of id
+	UnmatchedEmphasis                      // * or _ that doesn't open or close emphasis
+	UnclosedCodeFence                      // ``` or ~~~ code fence without a closing fence
+	TableColumnCount                       // table row without as many cells as the header
+)
+
+// Problem is an issue in a markdown document, as returned by Validate.
+type Problem struct {
+	Kind   ProblemKind
+	Offset int    // byte offset in the input
+	Msg    string // description of the problem
+}
+
+// Validate parses a markdown document with parser.CommonExtensions and
+// returns the problems found in it, ordered by offset. It's meant for linting
+// documents in authoring tools: the problems don't prevent rendering, but the
+// output is likely not what the author intended.
+//
+// Only tables at the top level of the document are checked.
+func Validate(input []byte) []Problem {
+	var problems []Problem
+	var missingRefs [][]byte
+
+	p := parser.NewWithExtensions(parser.CommonExtensions)
+	p.Opts.Flags |= parser.SourcePositions
+	p.OnMissingRef = func(id []byte) (url, title []byte, ok bool) {
+		missingRefs = append(missingRefs, id)
+		return nil, nil, false
+	}
+	doc := p.Parse(input)
+
+	problems = append(problems, unresolvedReferences(input, missingRefs)...)
+	problems = append(problems, unmatchedEmphasis(input, doc)...)
+	problems = append(problems, unclosedCodeFences(input, doc)...)
+	for _, child := range doc.GetChildren() {
+		if table, ok := child.(*ast.Table); ok {
+			problems = append(problems, tableColumnCounts(input, table)...)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].Offset < problems[j].Offset
+	})
+	return problems
+}
+
+// unresolvedReferences locates in input the reference links with ids, the
+// ids the parser couldn't resolve in document order. [id] shortcuts are
+// skipped, they're most likely brackets in the text.
+func unresolvedReferences(input []byte, ids [][]byte) []Problem {
+	var problems []Problem
+	cursor := 0
+	for _, id := range ids {
+		full := bytes.Index(input[cursor:], []byte("]["+string(id)+"]"))
+		collapsed := bytes.Index(input[cursor:], []byte("["+string(id)+"][]"))
+		var offset int
+		switch {
+		case full < 0 && collapsed < 0:
+			continue
+		case full >= 0 && (collapsed < 0 || full < collapsed):
+			offset = cursor + full + 1
+		default:
+			offset = cursor + collapsed
+		}
+		problems = append(problems, Problem{
+			Kind:   UnresolvedReference,
+			Offset: offset,
+			Msg:    fmt.Sprintf("reference %q is not defined", id),
+		})
+		cursor = offset + len(id) + 2
+	}
+	return problems
+}
+
+// unmatchedEmphasis returns the emphasis delimiters left in the text of doc
+// that look like they were meant to open or close emphasis.
+func unmatchedEmphasis(input []byte, doc ast.Node) []Problem {
+	var problems []Problem
+	cursor := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		text, ok := node.(*ast.Text)
+		if !ok || len(text.Literal) == 0 {
+			return ast.GoToNext
+		}
+		start := topLevelOffset(node)
+		if i := bytes.Index(input[cursor:], text.Literal); i >= 0 {
+			start = cursor + i
+			cursor = start + len(text.Literal)
+		}
+		for _, i := range emphasisDelimiters(text.Literal) {
+			problems = append(problems, Problem{
+				Kind:   UnmatchedEmphasis,
+				Offset: start + i,
+				Msg:    fmt.Sprintf("unmatched emphasis %q", text.Literal[i]),
+			})
+		}
+		return ast.GoToNext
+	})
+	return problems
+}
+
+// emphasisDelimiters returns the offsets of the runs of * and _ in text that
+// start a word and are followed by text, or end a word. Runs inside words,
+// e.g. in snake_case or 2*3, and runs surrounded by spaces are ignored.
+func emphasisDelimiters(text []byte) []int {
+	var delims []int
+	for i := 0; i < len(text); {
+		c := text[i]
+		if c != '*' && c != '_' {
+			i++
+			continue
+		}
+		end := i
+		for end < len(text) && text[end] == c {
+			end++
+		}
+		before, after := runeBefore(text, i), runeAt(text, end)
+		opens := isBoundary(before) && after != utf8.RuneError && !unicode.IsSpace(after)
+		closes := isBoundary(after) && before != utf8.RuneError && !unicode.IsSpace(before)
+		if opens != closes {
+			delims = append(delims, i)
+		}
+		i = end
+	}
+	return delims
+}
+
+// runeBefore returns the rune ending at data[i], or utf8.RuneError at the
+// start of data.
+func runeBefore(data []byte, i int) rune {
+	if i == 0 {
+		return utf8.RuneError
+	}
+	r, _ := utf8.DecodeLastRune(data[:i])
+	return r
+}
+
+// runeAt returns the rune starting at data[i], or utf8.RuneError at the end
+// of data.
+func runeAt(data []byte, i int) rune {
+	if i >= len(data) {
+		return utf8.RuneError
+	}
+	r, _ := utf8.DecodeRune(data[i:])
+	return r
+}
+
+// isBoundary returns true if r, the rune next to a delimiter run, ends a word.
+// utf8.RuneError stands for the start or end of the text.
+func isBoundary(r rune) bool {
+	return r == utf8.RuneError || unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
+
+// topLevelOffset returns the SourceOffset of the top-level block holding node,
+// or 0 if it's unknown, e.g. for the footnotes list.
+func topLevelOffset(node ast.Node) int {
+	for node.GetParent() != nil && node.GetParent().GetParent() != nil {
+		node = node.GetParent()
+	}
+	offset := 0
+	if c := node.AsContainer(); c != nil {
+		offset = c.SourceOffset
+	} else if l := node.AsLeaf(); l != nil {
+		offset = l.SourceOffset
+	}
+	if offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// unclosedCodeFences returns the fenced code blocks of doc without a closing
+// fence. The parser only knows the offsets of top-level blocks, so the fences
+// of nested code blocks are looked up in input from the start of their
+// top-level block.
+func unclosedCodeFences(input []byte, doc ast.Node) []Problem {
+	var problems []Problem
+	cursor := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		code, ok := node.(*ast.CodeBlock)
+		if !ok || !entering || !code.IsFenced {
+			return ast.GoToNext
+		}
+		fence := bytes.Repeat([]byte{code.FenceChar}, code.FenceLength)
+		offset := code.SourceOffset
+		if offset < 0 {
+			offset = topLevelOffset(node)
+		}
+		if offset < cursor {
+			offset = cursor
+		}
+		if i := bytes.Index(input[offset:], fence); i >= 0 {
+			offset += i
+		}
+		cursor = offset + len(fence)
+		if code.Unclosed {
+			problems = append(problems, Problem{
+				Kind:   UnclosedCodeFence,
+				Offset: offset,
+				Msg:    fmt.Sprintf("code fence %s is not closed", fence),
+			})
+		}
+		return ast.GoToNext
+	})
+	return problems
+}
+
+// tableColumnCounts returns the rows of table, a top-level block, with more
+// or fewer cells than its header in the input. The parser pads and truncates
+// rows, so the cells are counted in the input.
+func tableColumnCounts(input []byte, table *ast.Table) []Problem {
+	var problems []Problem
+	columns := -1
+	for offset := table.SourceOffset; offset < len(input); {
+		end := offset + bytes.IndexByte(input[offset:], '\n') + 1
+		if end == offset {
+			end = len(input)
+		}
+		line := bytes.TrimSpace(input[offset:end])
+		if len(line) == 0 || bytes.IndexByte(line, '|') < 0 {
+			break
+		}
+		if !isTableSeparator(line) {
+			n := tableCells(line)
+			if columns < 0 {
+				columns = n
+			} else if n != columns {
+				cells := "cells"
+				if n == 1 {
+					cells = "cell"
+				}
+				problems = append(problems, Problem{
+					Kind:   TableColumnCount,
+					Offset: offset,
+					Msg:    fmt.Sprintf("table row has %d %s instead of %d", n, cells, columns),
+				})
+			}
+		}
+		offset = end
+	}
+	return problems
+}
+
+// isTableSeparator returns true for the |---|:--:| line under the header and
+// the |===| line above the footer.
+func isTableSeparator(line []byte) bool {
+	return len(bytes.Trim(line, "|-=: \t")) == 0
+}
+
+// tableCells returns the number of cells of a table row, ignoring escaped
+// pipes and pipes in code spans.
+func tableCells(line []byte) int {
+	line = bytes.TrimPrefix(line, []byte("|"))
+	if len(line) > 0 && line[len(line)-1] == '|' && (len(line) < 2 || line[len(line)-2] != '\\') {
+		line = line[:len(line)-1]
+	}
+	cells := 1
+	inCode := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '`':
+			inCode = !inCode
+		case '|':
+			if !inCode {
+				cells++
+			}
+		}
+	}
+	return cells
+}
@@ -0,0 +1,61 @@
+package markdown
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []Problem
+	}{
+		{
+			"see [docs][guide] and [faq][], not [brackets]\n",
+			[]Problem{
+				{UnresolvedReference, 10, `reference "guide" is not defined`},
+				{UnresolvedReference, 22, `reference "faq" is not defined`},
+			},
+		},
+		{
+			"Hello *world, snake_case and 2 * 3\n\n**a** _b_ \\*c\n",
+			[]Problem{
+				{UnmatchedEmphasis, 6, `unmatched emphasis '*'`},
+			},
+		},
+		{
+			"# Code\n\n```go\nx := 1\n",
+			[]Problem{
+				{UnclosedCodeFence, 8, "code fence ``` is not closed"},
+			},
+		},
+		{
+			"| a | b |\n|---|---|\n| 1 |\n| 1 | 2 | 3 |\n| `x|y` | \\| |\n",
+			[]Problem{
+				{TableColumnCount, 20, "table row has 1 cell instead of 2"},
+				{TableColumnCount, 26, "table row has 3 cells instead of 2"},
+			},
+		},
+		{
+			"> ```\n> quoted\n\n- item\n\n  ~~~~\n  code\n",
+			[]Problem{
+				{UnclosedCodeFence, 2, "code fence ``` is not closed"},
+				{UnclosedCodeFence, 26, "code fence ~~~~ is not closed"},
+			},
+		},
+		{
+			"    indented\n    ```\n\n```\nclosed\n```\n",
+			nil,
+		},
+		{
+			"# Fine\n\n*a* [b][c]\n\n~~~\n```\n~~~\n\n[c]: /c\n",
+			nil,
+		},
+	}
+	for _, test := range tests {
+		got := Validate([]byte(test.input))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Validate(%q)\ngot  %+v\nwant %+v", test.input, got, test.want)
+		}
+	}
+}